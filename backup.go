@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// backupProgressInterval 备份进度日志的最小间隔
+const backupProgressInterval = 30 * time.Second
+
+// BackupResult 单个服务器 restic backup 的结果（来自 --json 输出的 summary 消息）
+type BackupResult struct {
+	ServerName string
+	// 新快照的完整 ID
+	SnapshotID string
+
+	FilesNew        int
+	FilesChanged    int
+	FilesUnmodified int
+	DirsNew         int
+	DirsChanged     int
+	DirsUnmodified  int
+
+	// 新增到仓库的数据量（压缩前和压缩后）
+	DataAdded       uint64
+	DataAddedPacked uint64
+	// 本次处理的文件总数和总大小
+	TotalFiles int
+	TotalBytes uint64
+
+	Duration time.Duration
+	// 无法读取的文件数量（restic 退出码 3，快照已创建但不完整）
+	Errors int
+}
+
+// ShortID 返回快照的短 ID
+func (r *BackupResult) ShortID() string {
+	if len(r.SnapshotID) > 8 {
+		return r.SnapshotID[:8]
+	}
+	return r.SnapshotID
+}
+
+// String 返回备份结果的简短描述
+func (r *BackupResult) String() string {
+	added := formatBytes(r.DataAdded)
+	if r.DataAddedPacked > 0 {
+		added = fmt.Sprintf("%s（压缩后 %s）", added, formatBytes(r.DataAddedPacked))
+	}
+	return fmt.Sprintf("快照 %s，文件新增 %d / 修改 %d / 未变 %d，新增数据 %s，处理 %s，耗时 %s",
+		r.ShortID(), r.FilesNew, r.FilesChanged, r.FilesUnmodified, added,
+		formatBytes(r.TotalBytes), r.Duration.Round(time.Second))
+}
+
+// resticBackupMessage restic backup --json 输出的一行消息
+// status、error 和 summary 消息共用一个结构，按 message_type 区分
+type resticBackupMessage struct {
+	MessageType string `json:"message_type"`
+
+	// status
+	PercentDone      float64 `json:"percent_done"`
+	TotalFiles       int     `json:"total_files"`
+	FilesDone        int     `json:"files_done"`
+	TotalBytes       uint64  `json:"total_bytes"`
+	BytesDone        uint64  `json:"bytes_done"`
+	SecondsRemaining int     `json:"seconds_remaining"`
+
+	// error
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+	During string `json:"during"`
+	Item   string `json:"item"`
+
+	// summary
+	FilesNew            int     `json:"files_new"`
+	FilesChanged        int     `json:"files_changed"`
+	FilesUnmodified     int     `json:"files_unmodified"`
+	DirsNew             int     `json:"dirs_new"`
+	DirsChanged         int     `json:"dirs_changed"`
+	DirsUnmodified      int     `json:"dirs_unmodified"`
+	DataAdded           uint64  `json:"data_added"`
+	DataAddedPacked     uint64  `json:"data_added_packed"`
+	TotalFilesProcessed int     `json:"total_files_processed"`
+	TotalBytesProcessed uint64  `json:"total_bytes_processed"`
+	TotalDuration       float64 `json:"total_duration"`
+	SnapshotID          string  `json:"snapshot_id"`
+}
+
+// performBackup 执行备份，返回 restic 报告的新快照和统计信息
+// 直接从 restic 的输出中取得快照 ID，不依赖备份前后的快照数量（并行备份时不准确）
+// 超过 backup_timeout 时中止 restic（restic 会删除自己的锁），由调用方恢复写入
+func performBackup(ctx context.Context, serverName string, config *Config) (*BackupResult, error) {
+	logger.Log("[%s] 开始增量备份（超时时间 %s）...", serverName, config.BackupTimeout)
+
+	backupCtx, cancel := context.WithTimeout(ctx, config.BackupTimeout)
+	defer cancel()
+
+	cmd := config.Repository.command(backupCtx, "backup",
+		"--json",
+		"--host", config.BackupHost,
+		"--tag", config.BackupTag,
+		config.WorldDir)
+
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	result := &BackupResult{ServerName: serverName}
+	var summary *resticBackupMessage
+	lastProgress := time.Now()
+
+	scanner := bufio.NewScanner(stdout)
+	// status 消息中包含正在处理的文件路径，单行可能很长
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var message resticBackupMessage
+		if err := json.Unmarshal(line, &message); err != nil {
+			logger.Debug("[%s] restic: %s", serverName, line)
+			continue
+		}
+
+		switch message.MessageType {
+		case "status":
+			if time.Since(lastProgress) < backupProgressInterval {
+				continue
+			}
+			lastProgress = time.Now()
+			logger.Log("[%s] 备份进度 %.0f%%: 文件 %d/%d，数据 %s/%s，预计剩余 %s", serverName,
+				message.PercentDone*100, message.FilesDone, message.TotalFiles,
+				formatBytes(message.BytesDone), formatBytes(message.TotalBytes),
+				time.Duration(message.SecondsRemaining)*time.Second)
+		case "error":
+			result.Errors++
+			logger.Log("[%s] 警告: 无法读取 %s: %s", serverName, message.Item, message.Error.Message)
+		case "summary":
+			summary = &message
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logger.Log("[%s] 警告: 读取 restic 输出失败: %v", serverName, err)
+		// 继续读完剩余的输出，否则 restic 写满管道后会一直阻塞到 backup_timeout
+		io.Copy(io.Discard, stdout)
+	}
+
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		logger.Log("[%s] 备份已中断", serverName)
+		return nil, ctx.Err()
+	}
+	if backupCtx.Err() != nil {
+		logger.Log("[%s] 备份超时，已中止 restic", serverName)
+		return nil, fmt.Errorf("restic backup 超过 %s 未完成（backup_timeout），已中止", config.BackupTimeout)
+	}
+	// 退出码 3 表示部分文件无法读取，但快照已经创建
+	if err != nil && !(resticExitCode(err) == resticExitIncomplete && summary != nil) {
+		logger.Log("[%s] 备份失败", serverName)
+		return nil, resticError(err, stderr.String())
+	}
+	if summary == nil || summary.SnapshotID == "" {
+		return nil, fmt.Errorf("restic backup 未报告新快照")
+	}
+
+	result.SnapshotID = summary.SnapshotID
+	result.FilesNew = summary.FilesNew
+	result.FilesChanged = summary.FilesChanged
+	result.FilesUnmodified = summary.FilesUnmodified
+	result.DirsNew = summary.DirsNew
+	result.DirsChanged = summary.DirsChanged
+	result.DirsUnmodified = summary.DirsUnmodified
+	result.DataAdded = summary.DataAdded
+	result.DataAddedPacked = summary.DataAddedPacked
+	result.TotalFiles = summary.TotalFilesProcessed
+	result.TotalBytes = summary.TotalBytesProcessed
+	result.Duration = time.Duration(summary.TotalDuration * float64(time.Second))
+
+	if result.Errors > 0 {
+		logger.Log("[%s] 警告: 备份完成但有 %d 个文件无法读取，快照可能不完整", serverName, result.Errors)
+	}
+	logger.Log("[%s] 备份成功完成: %s", serverName, result)
+	return result, nil
+}
+
+// BackupFailure 备份失败的服务器及原因
+type BackupFailure struct {
+	ServerName string
+	Err        error
+}
+
+// showBackupSummary 显示每个服务器的备份结果
+func showBackupSummary(results []*BackupResult, failures []BackupFailure) {
+	logger.Log("备份结果摘要:")
+	logger.Log("  成功: %d 个服务器", len(results))
+	for _, result := range results {
+		logger.Log("    %s: %s", result.ServerName, result)
+	}
+	logger.Log("  失败: %d 个服务器", len(failures))
+	for _, failure := range failures {
+		logger.Log("    %v", failure.Err)
+	}
+}
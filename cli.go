@@ -0,0 +1,583 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// GlobalOptions 全局命令行参数
+type GlobalOptions struct {
+	// 配置文件路径（为空时使用 getConfigPath）
+	ConfigPath string
+	// 只处理指定的服务器（支持通配符）
+	Servers stringList
+	// 排除的服务器（支持通配符）
+	Exclude stringList
+	// 允许处理 enabled = false 的服务器
+	IncludeDisabled bool
+	// 只显示将要执行的操作
+	DryRun bool
+	// 输出调试日志
+	Verbose bool
+}
+
+// configPath 返回实际使用的配置文件路径
+func (o *GlobalOptions) configPath() string {
+	if o.ConfigPath != "" {
+		return o.ConfigPath
+	}
+	return getConfigPath()
+}
+
+// selectServers 按 --server/--exclude 参数过滤服务器列表
+func (o *GlobalOptions) selectServers(multiConfig *MultiServerConfig) error {
+	return selectServers(multiConfig, o.Servers, o.Exclude, o.IncludeDisabled)
+}
+
+// stringList 可重复、可用逗号分隔的字符串参数
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// usageError 参数错误（退出码 2）
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// Command 子命令定义
+type Command struct {
+	Name    string
+	Usage   string
+	Summary string
+	Run     func(ctx context.Context, opts *GlobalOptions, args []string) error
+}
+
+// commands 子命令列表（未指定子命令时执行 backup）
+var commands []*Command
+
+func init() {
+	commands = []*Command{
+		{"backup", "backup", "备份服务器并清理旧快照（默认）", runBackup},
+		{"list", "list", "列出服务器的快照", runList},
+		{"restore", "restore <server> [snapshot-id|latest] [--at <time>]", "将快照恢复到服务器的世界目录", runRestore},
+		{"prune", "prune", "按保留策略清理旧快照", runPrune},
+		{"check", "check", "检查依赖、配置、仓库和服务器状态", runCheck},
+		{"init", "init", "初始化尚不存在的仓库和镜像仓库", runInit},
+		{"recover", "recover", "恢复上次异常退出时仍处于 save-off 状态的服务器", runRecover},
+		{"config", "config <validate|init>", "校验配置文件或创建示例配置", runConfig},
+		{"status", "status", "显示运行中的备份进程、服务器运行状态和最新快照", runStatus},
+	}
+}
+
+// addGlobalFlags 注册全局参数
+func addGlobalFlags(fs *flag.FlagSet, opts *GlobalOptions) {
+	fs.StringVar(&opts.ConfigPath, "config", opts.ConfigPath, "配置文件路径（默认读取 MINECRAFT_BACKUP_CONFIG）")
+	fs.Var(&opts.Servers, "server", "只处理指定的服务器（逗号分隔，可重复，支持 * ? [] 通配符）")
+	fs.Var(&opts.Exclude, "exclude", "排除指定的服务器（逗号分隔，可重复，支持通配符）")
+	fs.BoolVar(&opts.IncludeDisabled, "include-disabled", opts.IncludeDisabled, "允许处理 enabled = false 的服务器")
+	fs.BoolVar(&opts.DryRun, "dry-run", opts.DryRun, "只显示将要执行的操作")
+	fs.BoolVar(&opts.Verbose, "verbose", opts.Verbose, "输出调试日志")
+}
+
+// newFlagSet 创建子命令参数解析器，全局参数也可以写在子命令之后
+func newFlagSet(name string, opts *GlobalOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	addGlobalFlags(fs, opts)
+	return fs
+}
+
+// parseInterspersed 解析参数并返回位置参数
+// flag 包遇到第一个位置参数就会停止解析，这里允许参数和位置参数交错出现
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for len(args) > 0 {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, usageError(err.Error())
+		}
+		args = fs.Args()
+		if len(args) > 0 {
+			positional = append(positional, args[0])
+			args = args[1:]
+		}
+	}
+	return positional, nil
+}
+
+// printUsage 显示帮助信息
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "用法: minecraft-backup [全局参数] <命令> [参数]\n\n")
+	fmt.Fprintf(out, "命令:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-52s %s\n", cmd.Usage, cmd.Summary)
+	}
+	fmt.Fprintf(out, "\n全局参数:\n")
+	fs := flag.NewFlagSet("minecraft-backup", flag.ContinueOnError)
+	addGlobalFlags(fs, &GlobalOptions{})
+	fs.SetOutput(out)
+	fs.PrintDefaults()
+}
+
+// runCLI 解析命令行并执行子命令，返回退出码
+func runCLI(args []string) int {
+	opts := &GlobalOptions{}
+
+	fs := flag.NewFlagSet("minecraft-backup", flag.ContinueOnError)
+	addGlobalFlags(fs, opts)
+	fs.Usage = printUsage
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	args = fs.Args()
+
+	// 兼容旧用法：不带子命令时执行备份
+	name := "backup"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		printUsage()
+		return 0
+	}
+
+	var command *Command
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			command = cmd
+		}
+	}
+	if command == nil {
+		logger.Log("错误: 未知命令 %s", name)
+		printUsage()
+		return 2
+	}
+
+	logger.Verbose = opts.Verbose
+
+	// 收到 SIGINT/SIGTERM 时取消正在执行的操作
+	ctx, stop := shutdownContext()
+	defer stop()
+
+	if err := command.Run(ctx, opts, args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		logger.Log("错误: %v", err)
+		if ctx.Err() != nil {
+			return exitInterrupted
+		}
+		var usage usageError
+		if errors.As(err, &usage) {
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+// loadSelectedConfig 加载配置文件并按 --server 过滤
+func loadSelectedConfig(ctx context.Context, opts *GlobalOptions) (*MultiServerConfig, error) {
+	configPath := opts.configPath()
+	config, err := loadConfig(ctx, configPath)
+	if err != nil {
+		if strings.Contains(err.Error(), "配置文件不存在") {
+			return nil, fmt.Errorf("%v（可执行 config init 创建示例配置）", err)
+		}
+		return nil, fmt.Errorf("加载配置文件失败: %v", err)
+	}
+	if err := opts.selectServers(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// sortedServerNames 返回按名称排序的服务器列表
+func sortedServerNames(multiConfig *MultiServerConfig) []string {
+	names := make([]string, 0, len(multiConfig.Servers))
+	for name := range multiConfig.Servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runList 列出快照（list 子命令）
+func runList(ctx context.Context, opts *GlobalOptions, args []string) error {
+	fs := newFlagSet("list", opts)
+	if _, err := parseInterspersed(fs, args); err != nil {
+		return err
+	}
+
+	if err := checkDependencies("restic"); err != nil {
+		return err
+	}
+	config, err := loadSelectedConfig(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, serverName := range sortedServerNames(config) {
+		serverConfig := config.Servers[serverName]
+		snapshots, err := listSnapshots(ctx, serverConfig.Repository, serverConfig.BackupHost, serverConfig.BackupTag)
+		if err != nil {
+			return fmt.Errorf("服务器 %s: %v", serverName, err)
+		}
+
+		logger.Log("[%s] 共 %d 个快照（主机 %s，标签 %s）", serverName, len(snapshots), serverConfig.BackupHost, serverConfig.BackupTag)
+		for _, snapshot := range snapshots {
+			fmt.Printf("  %s  %s  %s\n", snapshot.ShortID, snapshot.Time.Local().Format("2006-01-02 15:04:05"), strings.Join(snapshot.Paths, ", "))
+		}
+	}
+	return nil
+}
+
+// runRestore 执行 restore 子命令
+func runRestore(ctx context.Context, opts *GlobalOptions, args []string) error {
+	restoreOpts, err := parseRestoreArgs(opts, args)
+	if err != nil {
+		return err
+	}
+
+	// 恢复的目标服务器由位置参数指定，未启用的服务器也可以恢复
+	opts.Servers = stringList{restoreOpts.ServerName}
+	opts.Exclude = nil
+	opts.IncludeDisabled = true
+
+	if err := checkDependencies("restic"); err != nil {
+		return err
+	}
+	config, err := loadSelectedConfig(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	// 恢复会停止服务器并替换世界目录，不能与备份同时进行
+	if !opts.DryRun {
+		lock, err := acquireRunLock(ctx, config, "restore")
+		if errors.Is(err, errRunLockSkipped) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer lock.release()
+	}
+
+	if err := checkRuntimes(ctx, config); err != nil {
+		return err
+	}
+
+	if err := checkRepositories(ctx, config); err != nil {
+		return err
+	}
+
+	return restoreServer(ctx, config, restoreOpts)
+}
+
+// runPrune 按保留策略清理旧快照（prune 子命令）
+func runPrune(ctx context.Context, opts *GlobalOptions, args []string) error {
+	fs := newFlagSet("prune", opts)
+	if _, err := parseInterspersed(fs, args); err != nil {
+		return err
+	}
+
+	if err := checkDependencies("restic"); err != nil {
+		return err
+	}
+	config, err := loadSelectedConfig(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	if !opts.DryRun {
+		lock, err := acquireRunLock(ctx, config, "prune")
+		if errors.Is(err, errRunLockSkipped) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer lock.release()
+	}
+
+	// 持有运行锁之后再恢复上次异常退出时没有恢复写入的服务器
+	if _, err := recoverSaveOff(ctx, config, opts.DryRun); err != nil {
+		logger.Log("警告: %v", err)
+	}
+
+	if err := checkRepositories(ctx, config); err != nil {
+		return err
+	}
+
+	// 按每个服务器的保留策略清理
+	return cleanupSnapshots(ctx, config, opts.DryRun)
+}
+
+// runInit 初始化服务器使用的仓库和镜像仓库（init 子命令）
+// 已经存在的仓库会跳过；无法确认仓库不存在时（密码错误、网络问题等）不会初始化
+func runInit(ctx context.Context, opts *GlobalOptions, args []string) error {
+	fs := newFlagSet("init", opts)
+	if _, err := parseInterspersed(fs, args); err != nil {
+		return err
+	}
+
+	if err := checkDependencies("restic"); err != nil {
+		return err
+	}
+	config, err := loadSelectedConfig(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	initialize := func(name string, repo, from *Repository) {
+		exists, err := repositoryExists(ctx, repo)
+		switch {
+		case err != nil:
+			logger.Log("  %s: 无法确认仓库是否存在，跳过: %v", name, err)
+			failed++
+		case exists:
+			logger.Log("  %s: 已存在，跳过", name)
+		case opts.DryRun:
+			logger.Log("  [dry-run] %s: 将执行 restic init", name)
+		default:
+			if err := initRepository(ctx, repo, from); err != nil {
+				logger.Log("  %s: 初始化失败: %v", name, err)
+				failed++
+				return
+			}
+			logger.Log("  %s: 初始化成功", name)
+		}
+	}
+
+	logger.Log("初始化仓库...")
+	repos := repositoriesOf(config)
+	for _, repo := range repos {
+		initialize(repo.String(), repo, nil)
+	}
+
+	// 只有一个主仓库时，镜像仓库使用相同的分块参数，restic copy 可以去重
+	var from *Repository
+	if len(repos) == 1 {
+		from = repos[0]
+	} else if len(config.Mirrors) > 0 {
+		logger.Log("服务器使用了多个仓库，镜像仓库使用默认的分块参数初始化")
+	}
+	for _, mirror := range config.Mirrors {
+		initialize(fmt.Sprintf("镜像 %s (%s)", mirror.Name, mirror.Repository), mirror.Repository, from)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d 个仓库初始化失败", failed)
+	}
+	return nil
+}
+
+// runRecover 对上次异常退出时没有恢复写入的服务器发送 save-on（recover 子命令）
+// 使用配置文件中的所有服务器，不受 --server 和 enabled 的限制
+func runRecover(ctx context.Context, opts *GlobalOptions, args []string) error {
+	fs := newFlagSet("recover", opts)
+	if _, err := parseInterspersed(fs, args); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(ctx, opts.configPath())
+	if err != nil {
+		return fmt.Errorf("加载配置文件失败: %v", err)
+	}
+
+	// 正在进行的备份会自己恢复写入，等它结束后再处理剩下的记录
+	if !opts.DryRun {
+		lock, err := acquireRunLock(ctx, config, "recover")
+		if errors.Is(err, errRunLockSkipped) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer lock.release()
+	}
+
+	pending, err := recoverSaveOff(ctx, config, opts.DryRun)
+	if err != nil {
+		return err
+	}
+	if pending == 0 {
+		logger.Log("没有处于 save-off 状态的服务器")
+	}
+	return nil
+}
+
+// runCheck 检查运行环境（check 子命令）
+func runCheck(ctx context.Context, opts *GlobalOptions, args []string) error {
+	fs := newFlagSet("check", opts)
+	if _, err := parseInterspersed(fs, args); err != nil {
+		return err
+	}
+
+	logger.Log("检查系统依赖...")
+	if err := checkDependencies("restic"); err != nil {
+		return err
+	}
+
+	config, err := loadSelectedConfig(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if err := checkRuntimes(ctx, config); err != nil {
+		return err
+	}
+	logger.Log("  依赖检查通过")
+	if problems := append(resolveWorldDirs(ctx, config), validateConfig(config)...); len(problems) > 0 {
+		for _, problem := range problems {
+			logger.Log("  %s", problem)
+		}
+		return fmt.Errorf("配置文件存在 %d 个问题", len(problems))
+	}
+	logger.Log("  配置检查通过")
+
+	if err := checkNetwork(ctx, config); err != nil {
+		return err
+	}
+
+	if err := checkRepositories(ctx, config); err != nil {
+		return err
+	}
+
+	var failedServers []string
+	for _, serverName := range sortedServerNames(config) {
+		if err := checkServerRunning(ctx, config.Servers[serverName]); err != nil {
+			failedServers = append(failedServers, serverName)
+		}
+	}
+	if len(failedServers) > 0 {
+		return fmt.Errorf("以下服务器未运行: %s", strings.Join(failedServers, ", "))
+	}
+
+	logger.Log("所有检查通过")
+	return nil
+}
+
+// runConfig 配置文件相关命令（config 子命令）
+func runConfig(ctx context.Context, opts *GlobalOptions, args []string) error {
+	fs := newFlagSet("config", opts)
+	force := fs.Bool("force", false, "config init 时覆盖已存在的配置文件")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError("用法: config <validate|init>")
+	}
+
+	configPath := opts.configPath()
+
+	switch positional[0] {
+	case "validate":
+		config, err := loadSelectedConfig(ctx, opts)
+		if err != nil {
+			return err
+		}
+		showConfig(config)
+		if problems := validateConfig(config); len(problems) > 0 {
+			for _, problem := range problems {
+				logger.Log("  %s", problem)
+			}
+			return fmt.Errorf("配置文件存在 %d 个问题", len(problems))
+		}
+		logger.Log("配置文件有效: %s", configPath)
+		return nil
+
+	case "init":
+		if _, err := os.Stat(configPath); err == nil && !*force {
+			return fmt.Errorf("配置文件已存在: %s（使用 --force 覆盖）", configPath)
+		}
+		if opts.DryRun {
+			logger.Log("[dry-run] 将创建示例配置文件: %s", configPath)
+			return nil
+		}
+		return createSampleConfig(configPath)
+
+	default:
+		return usageError("用法: config <validate|init>")
+	}
+}
+
+// runStatus 显示服务器状态（status 子命令）
+func runStatus(ctx context.Context, opts *GlobalOptions, args []string) error {
+	fs := newFlagSet("status", opts)
+	if _, err := parseInterspersed(fs, args); err != nil {
+		return err
+	}
+
+	if err := checkDependencies("restic"); err != nil {
+		return err
+	}
+	config, err := loadSelectedConfig(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	switch holder, err := runLockStatus(); {
+	case err != nil:
+		logger.Log("运行锁: 未知 (%v)", err)
+	case holder == nil:
+		logger.Log("运行锁: 空闲")
+	default:
+		logger.Log("运行锁: %s", holder)
+	}
+	reportSaveOff()
+
+	for _, serverName := range sortedServerNames(config) {
+		serverConfig := config.Servers[serverName]
+		logger.Log("[%s]", serverName)
+
+		controller, err := controllerFor(serverConfig)
+		if err != nil {
+			logger.Log("  运行状态: 未知 (%v)", err)
+		} else if running, err := controller.IsRunning(ctx); err != nil {
+			logger.Log("  运行状态: 未知 (%v)", err)
+		} else if running {
+			logger.Log("  运行状态: %s 运行中", controller)
+		} else {
+			logger.Log("  运行状态: %s 未运行", controller)
+		}
+
+		snapshots, err := listSnapshots(ctx, serverConfig.Repository, serverConfig.BackupHost, serverConfig.BackupTag)
+		switch {
+		case err != nil:
+			logger.Log("  最新快照: 未知 (%v)", err)
+		case len(snapshots) == 0:
+			logger.Log("  最新快照: 无")
+		default:
+			latest := snapshots[len(snapshots)-1]
+			logger.Log("  最新快照: %s (%s)，共 %d 个", latest.ShortID, latest.Time.Local().Format("2006-01-02 15:04:05"), len(snapshots))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DiscoveryConfig 容器自动发现配置
+type DiscoveryConfig struct {
+	// 是否启用自动发现
+	Enabled bool `toml:"enabled"`
+	// 使用的容器运行时（默认 docker）
+	Runtime string `toml:"runtime"`
+	// 标签前缀（默认 minecraft-backup）
+	LabelPrefix string `toml:"label_prefix"`
+	// 容器内的服务器数据目录（默认 /data，对应挂载的主机路径作为 world_dir）
+	DataPath string `toml:"data_path"`
+}
+
+// 自动发现的默认值
+const (
+	defaultDiscoveryLabelPrefix = "minecraft-backup"
+	defaultDiscoveryDataPath    = "/data"
+)
+
+// discoverServers 查找带有 <prefix>.enable=true 标签的运行中容器，并生成服务器配置
+// 标签:
+//
+//	<prefix>.enable         必须为 true
+//	<prefix>.name           服务器名称（默认容器名）
+//	<prefix>.world_dir      世界目录（默认为 data_path 挂载的主机路径）
+//	<prefix>.tag            备份标签（默认容器名）
+//	<prefix>.host           备份主机标识
+//	<prefix>.description    服务器描述
+//	<prefix>.rcon_host      RCON 地址（默认容器 IP）
+//	<prefix>.rcon_port      RCON 端口（默认读取容器环境变量 RCON_PORT）
+//	<prefix>.rcon_password  RCON 密码（默认读取容器环境变量 RCON_PASSWORD）
+func discoverServers(ctx context.Context, discovery DiscoveryConfig) (map[string]ServerConfig, error) {
+	prefix := discovery.LabelPrefix
+	if prefix == "" {
+		prefix = defaultDiscoveryLabelPrefix
+	}
+	dataPath := discovery.DataPath
+	if dataPath == "" {
+		dataPath = defaultDiscoveryDataPath
+	}
+
+	rt, err := getContainerRuntime(discovery.Runtime)
+	if err != nil {
+		return nil, err
+	}
+
+	containers, err := rt.ListContainers(ctx, false)
+	if err != nil {
+		return nil, fmt.Errorf("自动发现: 无法列出容器: %v", err)
+	}
+
+	servers := make(map[string]ServerConfig)
+	for _, container := range containers {
+		if !strings.EqualFold(container.Labels[prefix+".enable"], "true") {
+			continue
+		}
+
+		info, err := rt.InspectContainer(ctx, container.Name)
+		if err != nil {
+			logger.Log("警告: 自动发现: 无法获取容器 %s 的详情: %v", container.Name, err)
+			continue
+		}
+
+		serverName, serverConfig, err := serverConfigFromContainer(container.Name, info, prefix, dataPath)
+		if err != nil {
+			logger.Log("警告: 自动发现: 跳过容器 %s: %v", container.Name, err)
+			continue
+		}
+		serverConfig.Runtime = discovery.Runtime
+
+		if _, ok := servers[serverName]; ok {
+			logger.Log("警告: 自动发现: 服务器名称 %s 重复，跳过容器 %s", serverName, container.Name)
+			continue
+		}
+		servers[serverName] = serverConfig
+	}
+
+	return servers, nil
+}
+
+// serverConfigFromContainer 根据容器的标签、挂载和环境变量生成服务器配置
+func serverConfigFromContainer(containerName string, info *ContainerInfo, prefix, dataPath string) (string, ServerConfig, error) {
+	labels := info.Config.Labels
+	label := func(key string) string {
+		return strings.TrimSpace(labels[prefix+"."+key])
+	}
+
+	serverConfig := ServerConfig{
+		Type:          serverTypeDocker,
+		ContainerName: containerName,
+		WorldDir:      label("world_dir"),
+		BackupTag:     label("tag"),
+		BackupHost:    label("host"),
+		Description:   label("description"),
+		RconHost:      label("rcon_host"),
+		RconPassword:  label("rcon_password"),
+		Enabled:       true,
+	}
+
+	serverName := label("name")
+	if serverName == "" {
+		serverName = containerName
+	}
+	if serverConfig.BackupTag == "" {
+		serverConfig.BackupTag = containerName
+	}
+
+	// 世界目录：数据目录挂载的主机路径
+	if serverConfig.WorldDir == "" {
+		worldDir, err := hostPathForMount(info, dataPath)
+		if err != nil {
+			return "", ServerConfig{}, err
+		}
+		serverConfig.WorldDir = worldDir
+	}
+
+	// RCON：优先使用标签，其次使用容器环境变量（itzg/minecraft-server 的 RCON_PASSWORD/RCON_PORT）
+	env := containerEnv(info)
+	if serverConfig.RconPassword == "" {
+		serverConfig.RconPassword = env["RCON_PASSWORD"]
+	}
+	portValue := label("rcon_port")
+	if portValue == "" {
+		portValue = env["RCON_PORT"]
+	}
+	if portValue != "" {
+		port, err := strconv.Atoi(portValue)
+		if err != nil {
+			return "", ServerConfig{}, fmt.Errorf("RCON 端口无效: %s", portValue)
+		}
+		serverConfig.RconPort = port
+	}
+	if serverConfig.RconPassword != "" && serverConfig.RconHost == "" {
+		serverConfig.RconHost = containerIP(info)
+		// 无法直接访问容器网络时退回到容器内的 rcon-cli
+		if serverConfig.RconHost == "" {
+			serverConfig.RconPassword = ""
+		}
+	}
+
+	return serverName, serverConfig, nil
+}
+
+// containerEnv 将容器环境变量解析为 map
+func containerEnv(info *ContainerInfo) map[string]string {
+	env := make(map[string]string)
+	for _, item := range info.Config.Env {
+		if key, value, ok := strings.Cut(item, "="); ok {
+			env[key] = value
+		}
+	}
+	return env
+}
+
+// containerIP 返回容器的第一个网络地址（按网络名称排序）
+func containerIP(info *ContainerInfo) string {
+	names := make([]string, 0, len(info.NetworkSettings.Networks))
+	for name := range info.NetworkSettings.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if ip := info.NetworkSettings.Networks[name].IPAddress; net.ParseIP(ip) != nil {
+			return ip
+		}
+	}
+	return ""
+}
+
+// mergeDiscoveredServers 将自动发现的服务器合并到配置文件的服务器列表
+// 与配置文件中名称或容器名相同的服务器只补全未填写的字段，配置文件中的值优先
+func mergeDiscoveredServers(servers map[string]ServerConfig, discovered map[string]ServerConfig) map[string]ServerConfig {
+	if servers == nil {
+		servers = make(map[string]ServerConfig)
+	}
+
+	names := make([]string, 0, len(discovered))
+	for name := range discovered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		found := discovered[name]
+
+		existingName := ""
+		if _, ok := servers[name]; ok {
+			existingName = name
+		} else {
+			for configName, configured := range servers {
+				if configured.ContainerName != "" && configured.ContainerName == found.ContainerName {
+					existingName = configName
+					break
+				}
+			}
+		}
+
+		if existingName == "" {
+			logger.Log("自动发现服务器: %s（容器 %s）", name, found.ContainerName)
+			servers[name] = found
+			continue
+		}
+
+		configured := servers[existingName]
+		fillString(&configured.ContainerName, found.ContainerName)
+		fillString(&configured.WorldDir, found.WorldDir)
+		fillString(&configured.BackupTag, found.BackupTag)
+		fillString(&configured.BackupHost, found.BackupHost)
+		fillString(&configured.Description, found.Description)
+		fillString(&configured.Runtime, found.Runtime)
+		if configured.RconPassword == "" && found.RconPassword != "" {
+			configured.RconHost = found.RconHost
+			configured.RconPort = found.RconPort
+			configured.RconPassword = found.RconPassword
+		}
+		servers[existingName] = configured
+		logger.Debug("自动发现的容器 %s 已合并到服务器 %s", found.ContainerName, existingName)
+	}
+
+	return servers
+}
+
+// fillString 目标为空时使用 value
+func fillString(target *string, value string) {
+	if *target == "" {
+		*target = value
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigDiscoveryUnavailable(t *testing.T) {
+	useFakeRuntime(t, &fakeRuntime{listErr: errors.New("dial unix /var/run/docker.sock: connect: no such file or directory")})
+
+	write := func(content string) string {
+		path := filepath.Join(t.TempDir(), "config.toml")
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// 套接字不可用时仍然加载配置文件中的服务器
+	path := write(`
+[discovery]
+enabled = true
+
+[restic]
+repository = "/srv/restic"
+password = "secret"
+
+[servers.survival]
+type = "rcon-only"
+rcon_password = "secret"
+world_dir = "/srv/survival/world"
+`)
+	config, err := loadConfig(context.Background(), path)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if _, ok := config.Servers["survival"]; !ok || len(config.Servers) != 1 {
+		t.Errorf("servers = %q, want [survival]", sortedServerNames(config))
+	}
+
+	// 只依赖自动发现时报告发现失败的原因
+	path = write(`
+[discovery]
+enabled = true
+
+[restic]
+repository = "/srv/restic"
+password = "secret"
+`)
+	if _, err := loadConfig(context.Background(), path); err == nil {
+		t.Fatal("loadConfig() succeeded without any server")
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// 默认 Docker Engine API 套接字
+	defaultDockerSocket = "/var/run/docker.sock"
+	// 普通 API 请求的超时时间
+	dockerRequestTimeout = 30 * time.Second
+)
+
+// DockerAPIError Docker Engine API 返回的错误
+type DockerAPIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *DockerAPIError) Error() string {
+	return fmt.Sprintf("Docker API 错误 (%d): %s", e.StatusCode, e.Message)
+}
+
+// Is 使 errors.Is(err, errContainerNotFound) 能识别 404 错误
+func (e *DockerAPIError) Is(target error) bool {
+	return target == errContainerNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// dockerContainerSummary 容器列表项（GET /containers/json）
+type dockerContainerSummary struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	State  string            `json:"State"`
+	Status string            `json:"Status"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// DockerClient 通过 unix 套接字访问 Docker Engine API 的客户端
+// Podman 的 Docker 兼容套接字使用同一个客户端
+type DockerClient struct {
+	name   string
+	socket string
+	http   *http.Client
+}
+
+// dockerSocketPath 返回 Docker 套接字路径（支持 DOCKER_HOST=unix://...）
+func dockerSocketPath() string {
+	if host := os.Getenv("DOCKER_HOST"); strings.HasPrefix(host, "unix://") {
+		return strings.TrimPrefix(host, "unix://")
+	}
+	return defaultDockerSocket
+}
+
+// newDockerClient 创建 Docker Engine API 客户端
+func newDockerClient(name, socket string) *DockerClient {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &DockerClient{
+		name:   name,
+		socket: socket,
+		http:   &http.Client{Transport: transport},
+	}
+}
+
+// request 发送 API 请求，调用方负责关闭响应
+func (c *DockerClient) request(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	u := "http://docker" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	logger.Debug("Docker API: %s %s", method, path)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("无法访问 Docker 套接字 %s: %w", c.socket, err)
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(data, &apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return nil, &DockerAPIError{StatusCode: resp.StatusCode, Message: apiErr.Message}
+	}
+
+	return resp, nil
+}
+
+// requestJSON 发送 API 请求并解析 JSON 响应（out 为 nil 时丢弃响应）
+func (c *DockerClient) requestJSON(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	resp, err := c.request(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("解析 Docker API 响应失败: %v", err)
+	}
+	return nil
+}
+
+// String 返回运行时描述
+func (c *DockerClient) String() string {
+	return fmt.Sprintf("%s (unix://%s)", c.name, c.socket)
+}
+
+// Ping 检查 Docker 服务是否可用
+func (c *DockerClient) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, dockerRequestTimeout)
+	defer cancel()
+	return c.requestJSON(ctx, http.MethodGet, "/_ping", nil, nil, nil)
+}
+
+// ListContainers 列出容器（all 为 false 时只列出运行中的容器）
+func (c *DockerClient) ListContainers(ctx context.Context, all bool) ([]ContainerSummary, error) {
+	ctx, cancel := context.WithTimeout(ctx, dockerRequestTimeout)
+	defer cancel()
+
+	query := url.Values{}
+	if all {
+		query.Set("all", "1")
+	}
+
+	var containers []dockerContainerSummary
+	if err := c.requestJSON(ctx, http.MethodGet, "/containers/json", query, nil, &containers); err != nil {
+		return nil, err
+	}
+
+	summaries := make([]ContainerSummary, 0, len(containers))
+	for _, container := range containers {
+		name := ""
+		if len(container.Names) > 0 {
+			name = strings.TrimPrefix(container.Names[0], "/")
+		}
+		summaries = append(summaries, ContainerSummary{
+			ID:     container.ID,
+			Name:   name,
+			State:  container.State,
+			Status: container.Status,
+			Labels: container.Labels,
+		})
+	}
+	return summaries, nil
+}
+
+// InspectContainer 获取容器详情
+func (c *DockerClient) InspectContainer(ctx context.Context, name string) (*ContainerInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, dockerRequestTimeout)
+	defer cancel()
+
+	var info ContainerInfo
+	if err := c.requestJSON(ctx, http.MethodGet, "/containers/"+url.PathEscape(name)+"/json", nil, nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// StopContainer 停止容器（timeout 为等待容器自行退出的时间）
+func (c *DockerClient) StopContainer(ctx context.Context, name string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout+dockerRequestTimeout)
+	defer cancel()
+
+	query := url.Values{"t": {strconv.Itoa(int(timeout.Seconds()))}}
+	return c.requestJSON(ctx, http.MethodPost, "/containers/"+url.PathEscape(name)+"/stop", query, nil, nil)
+}
+
+// StartContainer 启动容器
+func (c *DockerClient) StartContainer(ctx context.Context, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, dockerRequestTimeout)
+	defer cancel()
+	return c.requestJSON(ctx, http.MethodPost, "/containers/"+url.PathEscape(name)+"/start", nil, nil, nil)
+}
+
+// Exec 在容器内执行命令，返回合并后的 stdout/stderr 输出
+// 命令退出码非 0 时返回错误
+func (c *DockerClient) Exec(ctx context.Context, name string, timeout time.Duration, cmd ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// 创建 exec 实例
+	var created struct {
+		ID string `json:"Id"`
+	}
+	createBody := map[string]interface{}{
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Cmd":          cmd,
+	}
+	if err := c.requestJSON(ctx, http.MethodPost, "/containers/"+url.PathEscape(name)+"/exec", nil, createBody, &created); err != nil {
+		return "", err
+	}
+
+	// 启动并读取输出，命令结束时服务端关闭连接
+	resp, err := c.request(ctx, http.MethodPost, "/exec/"+created.ID+"/start", nil, map[string]interface{}{"Detach": false, "Tty": false})
+	if err != nil {
+		return "", err
+	}
+	output, err := readDockerStream(resp.Body)
+	resp.Body.Close()
+	if ctx.Err() != nil {
+		return output, ctx.Err()
+	}
+	if err != nil {
+		return output, err
+	}
+
+	// 获取退出码
+	var inspect struct {
+		ExitCode int  `json:"ExitCode"`
+		Running  bool `json:"Running"`
+	}
+	if err := c.requestJSON(ctx, http.MethodGet, "/exec/"+created.ID+"/json", nil, nil, &inspect); err != nil {
+		return output, err
+	}
+	if inspect.ExitCode != 0 {
+		return output, fmt.Errorf("命令 %s 退出码 %d: %s", strings.Join(cmd, " "), inspect.ExitCode, strings.TrimSpace(output))
+	}
+
+	return output, nil
+}
+
+// Logs 获取容器自 since 以来的日志（stdout 和 stderr）
+func (c *DockerClient) Logs(ctx context.Context, name string, since time.Time) (string, error) {
+	info, err := c.InspectContainer(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dockerRequestTimeout)
+	defer cancel()
+
+	query := url.Values{
+		"stdout": {"1"},
+		"stderr": {"1"},
+		"since":  {strconv.FormatInt(since.Unix(), 10)},
+	}
+	resp, err := c.request(ctx, http.MethodGet, "/containers/"+url.PathEscape(name)+"/logs", query, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// 分配了 TTY 的容器日志是原始输出，否则是多路复用格式
+	if info.Config.Tty {
+		data, err := io.ReadAll(resp.Body)
+		return string(data), err
+	}
+	return readDockerStream(resp.Body)
+}
+
+// readDockerStream 解析多路复用的 stdout/stderr 输出流
+// 每一帧由 8 字节头（流类型、3 字节填充、4 字节大端长度）和数据组成
+func readDockerStream(r io.Reader) (string, error) {
+	var output bytes.Buffer
+	header := make([]byte, 8)
+
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return output.String(), nil
+			}
+			return output.String(), err
+		}
+
+		size := binary.BigEndian.Uint32(header[4:8])
+		if _, err := io.CopyN(&output, r, int64(size)); err != nil {
+			return output.String(), err
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dockerTestServer 在 unix 套接字上启动模拟的 Docker Engine API，返回连接它的客户端
+func dockerTestServer(t *testing.T, handler http.Handler) *DockerClient {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return newDockerClient(runtimeDocker, socket)
+}
+
+// dockerFrame 构造多路复用流中的一帧（stream 为 1 表示 stdout，2 表示 stderr）
+func dockerFrame(stream byte, data string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+	return append(header, data...)
+}
+
+func TestDockerInspectContainer(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/mc/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"Id":"abc","Name":"/mc","State":{"Running":true},
+			"Mounts":[{"Type":"bind","Source":"/srv/mc","Destination":"/data"}]}`))
+	})
+	mux.HandleFunc("/containers/gone/json", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"No such container: gone"}`))
+	})
+	client := dockerTestServer(t, mux)
+
+	info, err := client.InspectContainer(context.Background(), "mc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.State.Running || len(info.Mounts) != 1 || info.Mounts[0].Source != "/srv/mc" {
+		t.Errorf("InspectContainer() = %+v", info)
+	}
+
+	_, err = client.InspectContainer(context.Background(), "gone")
+	if !errors.Is(err, errContainerNotFound) {
+		t.Fatalf("InspectContainer(gone) error = %v, want errContainerNotFound", err)
+	}
+	if !strings.Contains(err.Error(), "No such container: gone") {
+		t.Errorf("error message = %q", err)
+	}
+
+	// 其他错误不能被识别为容器不存在
+	if errors.Is(&DockerAPIError{StatusCode: http.StatusInternalServerError}, errContainerNotFound) {
+		t.Error("500 error is treated as errContainerNotFound")
+	}
+}
+
+func TestReadDockerStream(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(dockerFrame(1, "Saved the game\n"))
+	stream.Write(dockerFrame(2, "warning\n"))
+	stream.Write(dockerFrame(1, ""))
+	stream.Write(dockerFrame(1, "done"))
+
+	output, err := readDockerStream(&stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Saved the game\nwarning\ndone"; output != want {
+		t.Errorf("readDockerStream() = %q, want %q", output, want)
+	}
+
+	// 连接在帧中间断开时返回已收到的数据和错误
+	truncated := append(dockerFrame(1, "partial"), dockerFrame(1, "lost data")[:12]...)
+	output, err = readDockerStream(bytes.NewReader(truncated))
+	if err == nil {
+		t.Error("readDockerStream() accepted a truncated frame")
+	}
+	if output != "partiallost" {
+		t.Errorf("readDockerStream() output of a truncated stream = %q", output)
+	}
+}
+
+func TestDockerExec(t *testing.T) {
+	for _, tt := range []struct {
+		exitCode int
+		wantErr  bool
+	}{
+		{0, false},
+		{1, true},
+	} {
+		var gotCmd []string
+		mux := http.NewServeMux()
+		mux.HandleFunc("/containers/mc/exec", func(w http.ResponseWriter, r *http.Request) {
+			var body struct {
+				Cmd []string `json:"Cmd"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			gotCmd = body.Cmd
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"Id":"e1"}`))
+		})
+		mux.HandleFunc("/exec/e1/start", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/vnd.docker.raw-stream")
+			w.Write(dockerFrame(1, "Automatic saving is now disabled\n"))
+			w.Write(dockerFrame(2, "rcon warning\n"))
+		})
+		mux.HandleFunc("/exec/e1/json", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]interface{}{"ExitCode": tt.exitCode, "Running": false})
+		})
+		client := dockerTestServer(t, mux)
+
+		output, err := client.Exec(context.Background(), "mc", 5*time.Second, "rcon-cli", "save-off")
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("exit code %d: Exec() error = %v, want error %v", tt.exitCode, err, tt.wantErr)
+		}
+		if want := "Automatic saving is now disabled\nrcon warning\n"; output != want {
+			t.Errorf("exit code %d: Exec() output = %q, want %q", tt.exitCode, output, want)
+		}
+		if !reflect.DeepEqual(gotCmd, []string{"rcon-cli", "save-off"}) {
+			t.Errorf("exec Cmd = %q", gotCmd)
+		}
+		if tt.wantErr && !strings.Contains(err.Error(), "退出码 1") {
+			t.Errorf("Exec() error = %q, want the exit code", err)
+		}
+	}
+}
+
+func TestDockerExecContainerNotFound(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/gone/exec", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"No such container: gone"}`))
+	})
+	client := dockerTestServer(t, mux)
+
+	_, err := client.Exec(context.Background(), "gone", 5*time.Second, "rcon-cli", "list")
+	if !isNotFoundError(err) {
+		t.Fatalf("Exec() error = %v, want errContainerNotFound", err)
+	}
+}
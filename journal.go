@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// saveJournalFile 记录未恢复写入的服务器的文件名（位于状态目录中）
+const saveJournalFile = "save-off.json"
+
+// stateDir 返回保存运行状态的目录
+func stateDir() string {
+	// 优先使用环境变量指定的目录，其次是 systemd 的 StateDirectory=
+	if dir := os.Getenv("MINECRAFT_BACKUP_STATE_DIR"); dir != "" {
+		return dir
+	}
+	if dir := os.Getenv("STATE_DIRECTORY"); dir != "" {
+		return dir
+	}
+
+	if os.Geteuid() == 0 {
+		return "/var/lib/minecraft-backup"
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "minecraft-backup")
+	}
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".local", "state", "minecraft-backup")
+}
+
+// saveOffEntry 一次尚未恢复写入的 save-off
+type saveOffEntry struct {
+	Since    time.Time `json:"since"`
+	PID      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+}
+
+// running 判断记录该条目的本机进程是否仍在运行
+// 主机重启后 PID 可能被其他进程复用，重启之前的记录一律视为进程已退出
+func (e saveOffEntry) running() bool {
+	if boot, err := bootTime(); err == nil && e.Since.Before(boot) {
+		return false
+	}
+	return processExists(e.PID)
+}
+
+// inProgress 判断记录是否属于本机上另一个仍持有运行锁的备份进程
+// 进程被 SIGKILL 后 PID 可能被无关进程复用，只凭 PID 存在不能判断
+func (e saveOffEntry) inProgress(holder *runLockHolder) bool {
+	hostname, _ := os.Hostname()
+	return e.Hostname == hostname && e.PID != os.Getpid() && e.running() &&
+		holder != nil && holder.PID == e.PID
+}
+
+// bootTime 读取 /proc/stat 中的系统启动时间
+func bootTime() (time.Time, error) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "btime "); ok {
+			seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(seconds, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("/proc/stat 中没有 btime")
+}
+
+// saveJournal 记录执行过 save-off 但还没有恢复写入的服务器
+// 进程被 SIGKILL 或主机重启时，下次启动根据记录重新发送 save-on
+type saveJournal struct {
+	path string
+}
+
+// openSaveJournal 打开状态目录中的 save-off 记录
+func openSaveJournal() (*saveJournal, error) {
+	dir := stateDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("无法创建状态目录 %s: %v", dir, err)
+	}
+	return &saveJournal{path: filepath.Join(dir, saveJournalFile)}, nil
+}
+
+// entries 读取所有记录
+func (j *saveJournal) entries() (map[string]saveOffEntry, error) {
+	entries := make(map[string]saveOffEntry)
+	data, err := os.ReadFile(j.path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return entries, nil
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %v", j.path, err)
+	}
+	return entries, nil
+}
+
+// update 在文件锁保护下读取、修改并写回记录，多个进程同时运行时不会互相覆盖
+func (j *saveJournal) update(modify func(entries map[string]saveOffEntry)) error {
+	lock, err := os.OpenFile(j.path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("无法锁定 %s: %v", j.path, err)
+	}
+
+	entries, err := j.entries()
+	if err != nil {
+		return err
+	}
+	modify(entries)
+
+	// 先写入临时文件再重命名，写入过程中断电也不会损坏记录
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := j.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.path)
+}
+
+// record 记录服务器即将执行 save-off
+func (j *saveJournal) record(serverName string) error {
+	hostname, _ := os.Hostname()
+	entry := saveOffEntry{Since: time.Now(), PID: os.Getpid(), Hostname: hostname}
+	return j.update(func(entries map[string]saveOffEntry) {
+		entries[serverName] = entry
+	})
+}
+
+// clear 删除服务器的记录（已恢复写入）
+func (j *saveJournal) clear(serverName string) error {
+	return j.update(func(entries map[string]saveOffEntry) {
+		delete(entries, serverName)
+	})
+}
+
+// recoverSaveOff 对记录中没有恢复写入的服务器重新发送 save-on，返回找到的记录数量
+// 本机上另一个正在进行的备份（进程仍在运行并持有运行锁）的记录会跳过
+func recoverSaveOff(ctx context.Context, multiConfig *MultiServerConfig, dryRun bool) (int, error) {
+	journal, err := openSaveJournal()
+	if err != nil {
+		return 0, err
+	}
+	entries, err := journal.entries()
+	if err != nil {
+		return 0, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// 调用方通常已持有运行锁，只有 dry-run 时才可能遇到正在进行的备份
+	holder, err := runLockStatus()
+	if err != nil {
+		logger.Log("警告: 无法读取运行锁: %v", err)
+	}
+
+	// 按 --server 过滤后仍然恢复配置文件中的所有服务器
+	servers := multiConfig.AllServers
+	if servers == nil {
+		servers = multiConfig.Servers
+	}
+
+	failed := 0
+	for _, name := range names {
+		entry := entries[name]
+		since := entry.Since.Format("2006-01-02 15:04:05")
+		if entry.inProgress(holder) {
+			logger.Log("服务器 %s 正在被进程 %d 备份（%s 暂停写入），跳过恢复", name, entry.PID, since)
+			continue
+		}
+
+		config, ok := servers[name]
+		if !ok {
+			logger.Log("警告: 服务器 %s 在 %s 暂停写入后没有恢复，但配置中已不存在该服务器，请手动执行 save-on", name, since)
+			if !dryRun {
+				if err := journal.clear(name); err != nil {
+					return len(entries), err
+				}
+			}
+			continue
+		}
+
+		logger.Log("服务器 %s 在 %s 暂停写入后没有恢复（进程 %d 异常退出）", name, since, entry.PID)
+		if dryRun {
+			logger.Log("[dry-run] 将向服务器 %s 发送 save-on", name)
+			continue
+		}
+		if err := runServerCommand(ctx, name, config, "save-on"); err != nil {
+			logger.Log("警告: 服务器 %s 无法执行 save-on 命令: %v", name, err)
+			failed++
+			continue
+		}
+		if err := journal.clear(name); err != nil {
+			return len(entries), err
+		}
+		logger.Log("[%s] 已恢复世界写入", name)
+	}
+
+	if failed > 0 {
+		return len(entries), fmt.Errorf("%d 个服务器未能恢复写入，下次运行时会重试", failed)
+	}
+	return len(entries), nil
+}
+
+// reportSaveOff 显示记录中没有恢复写入的服务器，只读取记录，不发送 save-on
+func reportSaveOff() {
+	journal := &saveJournal{path: filepath.Join(stateDir(), saveJournalFile)}
+	entries, err := journal.entries()
+	if err != nil {
+		logger.Log("未恢复写入: 未知 (%v)", err)
+		return
+	}
+	if len(entries) == 0 {
+		logger.Log("未恢复写入: 无")
+		return
+	}
+
+	holder, err := runLockStatus()
+	if err != nil {
+		logger.Log("警告: 无法读取运行锁: %v", err)
+	}
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entry := entries[name]
+		since := entry.Since.Format("2006-01-02 15:04:05")
+		if entry.inProgress(holder) {
+			logger.Log("未恢复写入: %s（%s 起，进程 %d 正在备份）", name, since, entry.PID)
+		} else {
+			logger.Log("未恢复写入: %s（%s 起，进程 %d 已退出，执行 recover 或下次备份时恢复）", name, since, entry.PID)
+		}
+	}
+}
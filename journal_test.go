@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecoverSaveOffReusedPID(t *testing.T) {
+	t.Setenv("MINECRAFT_BACKUP_STATE_DIR", t.TempDir())
+
+	var mu sync.Mutex
+	var commands []string
+	address := vanillaRconServer(t, "secret", func(command string) string {
+		mu.Lock()
+		commands = append(commands, command)
+		mu.Unlock()
+		return "Automatic saving is now enabled"
+	})
+	host, port, _ := net.SplitHostPort(address)
+	portNumber, _ := strconv.Atoi(port)
+	multi := &MultiServerConfig{Servers: map[string]*Config{
+		"survival": {Type: "rcon-only", RconHost: host, RconPort: portNumber, RconPassword: "secret", RconTimeout: 2 * time.Second},
+	}}
+
+	// 备份进程被 SIGKILL 后 PID 被一个无关的进程复用：进程存在，但没有持有运行锁
+	journal, err := openSaveJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostname, _ := os.Hostname()
+	entry := saveOffEntry{Since: time.Now(), PID: os.Getppid(), Hostname: hostname}
+	if !entry.running() {
+		t.Skip("parent process is not visible")
+	}
+	if err := journal.update(func(entries map[string]saveOffEntry) { entries["survival"] = entry }); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := recoverSaveOff(context.Background(), multi, false)
+	if err != nil || found != 1 {
+		t.Fatalf("recoverSaveOff() = %d, %v", found, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(commands) != 1 || commands[0] != "save-on" {
+		t.Errorf("commands sent = %q, want [save-on]", commands)
+	}
+	entries, err := journal.entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("journal entries after recovery = %v", entries)
+	}
+}
+
+func TestRecoverSaveOffOnlyWhenRequested(t *testing.T) {
+	t.Setenv("MINECRAFT_BACKUP_STATE_DIR", t.TempDir())
+
+	var mu sync.Mutex
+	var commands []string
+	address := vanillaRconServer(t, "secret", func(command string) string {
+		mu.Lock()
+		commands = append(commands, command)
+		mu.Unlock()
+		return "Automatic saving is now enabled"
+	})
+	host, port, _ := net.SplitHostPort(address)
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	config := fmt.Sprintf(`
+[restic]
+repository = "/srv/restic"
+password = "secret"
+
+[servers.survival]
+enabled = true
+type = "rcon-only"
+rcon_host = %q
+rcon_port = %s
+rcon_password = "secret"
+world_dir = "/srv/survival/world"
+
+[servers.creative]
+enabled = true
+type = "rcon-only"
+rcon_password = "secret"
+world_dir = "/srv/creative/world"
+`, host, port)
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// 主机重启之前留下的记录
+	journal, err := openSaveJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostname, _ := os.Hostname()
+	entry := saveOffEntry{Since: time.Unix(0, 0), PID: os.Getppid(), Hostname: hostname}
+	if err := journal.update(func(entries map[string]saveOffEntry) { entries["survival"] = entry }); err != nil {
+		t.Fatal(err)
+	}
+
+	// list、status 等只读命令加载配置时不能发送 save-on
+	opts := &GlobalOptions{ConfigPath: configPath, Servers: stringList{"creative"}}
+	multi, err := loadSelectedConfig(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if len(commands) != 0 {
+		t.Errorf("commands sent while loading the configuration = %q", commands)
+	}
+	mu.Unlock()
+
+	// 持有运行锁之后恢复，未被 --server 选中的服务器也会恢复
+	found, err := recoverSaveOff(context.Background(), multi, false)
+	if err != nil || found != 1 {
+		t.Fatalf("recoverSaveOff() = %d, %v", found, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(commands) != 1 || commands[0] != "save-on" {
+		t.Errorf("commands sent = %q, want [save-on]", commands)
+	}
+	entries, err := journal.entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("journal entries after recovery = %v", entries)
+	}
+}
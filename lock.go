@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// lockStaleAge 超过这个时间未刷新的锁视为过期（与 restic unlock 的判断一致，
+	// 运行中的 restic 每 5 分钟刷新一次锁）
+	lockStaleAge = 30 * time.Minute
+
+	// defaultLockWait 仓库被其他进程锁定时默认的最长等待时间（秒）
+	defaultLockWait = 600
+
+	// 等待锁的退避间隔
+	lockBackoffInitial = 10 * time.Second
+	lockBackoffMax     = 2 * time.Minute
+)
+
+// resticLock restic cat lock 输出的锁信息
+type resticLock struct {
+	ID        string    `json:"-"`
+	Time      time.Time `json:"time"`
+	Exclusive bool      `json:"exclusive"`
+	Hostname  string    `json:"hostname"`
+	Username  string    `json:"username"`
+	PID       int       `json:"pid"`
+}
+
+// String 返回锁的持有者描述
+func (l resticLock) String() string {
+	kind := "共享锁"
+	if l.Exclusive {
+		kind = "独占锁"
+	}
+	return fmt.Sprintf("%s@%s 进程 %d 的%s（%s 前刷新，ID %.8s）",
+		l.Username, l.Hostname, l.PID, kind, time.Since(l.Time).Round(time.Second), l.ID)
+}
+
+// staleReason 返回锁过期的原因，锁仍然有效时返回空字符串
+// 只有超过 lockStaleAge 未刷新的锁，或本机上已经退出的进程持有的锁才视为过期
+func (l resticLock) staleReason(hostname string) string {
+	if age := time.Since(l.Time); age > lockStaleAge {
+		return fmt.Sprintf("已 %s 未刷新", age.Round(time.Minute))
+	}
+	if l.Hostname == hostname && !processExists(l.PID) {
+		return fmt.Sprintf("本机进程 %d 已不存在", l.PID)
+	}
+	return ""
+}
+
+// processExists 判断本机上的进程是否存在
+func processExists(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// isRepositoryLocked 判断 restic 的错误输出是否表示仓库被锁定
+func isRepositoryLocked(output string) bool {
+	return strings.Contains(output, "repository is already locked") ||
+		strings.Contains(output, "unable to create lock")
+}
+
+// listLocks 读取仓库中的所有锁
+func listLocks(ctx context.Context, repo *Repository) ([]resticLock, error) {
+	output, err := repo.command(ctx, "list", "locks", "--no-lock").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var locks []resticLock
+	for _, id := range strings.Fields(string(output)) {
+		data, err := repo.command(ctx, "cat", "lock", id, "--no-lock").Output()
+		if err != nil {
+			// 锁可能在列出之后已被持有者释放
+			logger.Debug("读取锁 %s 失败: %v", id, err)
+			continue
+		}
+		lock := resticLock{ID: id}
+		if err := json.Unmarshal(data, &lock); err != nil {
+			return nil, fmt.Errorf("解析锁 %s 失败: %v", id, err)
+		}
+		locks = append(locks, lock)
+	}
+	return locks, nil
+}
+
+// lockWaiter 处理仓库被锁定的情况：删除过期的锁，其他锁按退避间隔等待
+type lockWaiter struct {
+	repo     *Repository
+	deadline time.Time
+	delay    time.Duration
+	// 已经尝试删除过的过期锁
+	unlocked map[string]bool
+}
+
+// newLockWaiter 创建锁等待器，最长等待时间从第一次遇到锁开始计算
+func newLockWaiter(repo *Repository) *lockWaiter {
+	return &lockWaiter{repo: repo, delay: lockBackoffInitial, unlocked: make(map[string]bool)}
+}
+
+// wait 在仓库被锁定后调用，返回 nil 表示可以重试命令
+// 过期的锁通过 restic unlock 删除（restic unlock 只会删除过期的锁）；
+// 其他主机或本机仍在运行的进程持有的锁不会删除，等待其释放，超过 lock_wait 后返回错误
+func (w *lockWaiter) wait(ctx context.Context) error {
+	if w.deadline.IsZero() {
+		w.deadline = time.Now().Add(w.repo.LockWait)
+	}
+
+	locks, err := listLocks(ctx, w.repo)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("仓库被锁定且无法读取锁信息: %v", err)
+	}
+
+	hostname, _ := os.Hostname()
+	var active []resticLock
+	staleFound := false
+	for _, lock := range locks {
+		reason := lock.staleReason(hostname)
+		switch {
+		case reason == "":
+			active = append(active, lock)
+		case w.unlocked[lock.ID]:
+			// restic unlock 没有删除这个锁，不再重复尝试
+			logger.Log("过期的锁未能删除: %s", lock)
+			active = append(active, lock)
+		default:
+			logger.Log("发现过期的锁: %s，%s", lock, reason)
+			w.unlocked[lock.ID] = true
+			staleFound = true
+		}
+	}
+
+	if staleFound {
+		if err := w.repo.command(ctx, "unlock").Run(); err != nil {
+			return fmt.Errorf("删除过期的锁失败: %v", err)
+		}
+		logger.Log("已删除过期的锁")
+	}
+	if len(active) == 0 {
+		if staleFound {
+			return nil
+		}
+		// 锁在读取之前已被持有者释放，稍后重试
+		if time.Now().After(w.deadline) {
+			return fmt.Errorf("仓库仍被锁定，但没有读取到任何锁")
+		}
+		return sleepContext(ctx, 2*time.Second)
+	}
+
+	for _, lock := range active {
+		logger.Log("仓库正在被使用: %s", lock)
+	}
+	remaining := time.Until(w.deadline)
+	if remaining <= 0 {
+		return fmt.Errorf("仓库被 %s 锁定，已等待 %s（lock_wait）", active[0], w.repo.LockWait)
+	}
+
+	delay := w.delay
+	if delay > remaining {
+		delay = remaining
+	}
+	logger.Log("等待 %s 后重试（最多再等待 %s）...", delay.Round(time.Second), remaining.Round(time.Second))
+	if err := sleepContext(ctx, delay); err != nil {
+		return err
+	}
+
+	w.delay *= 2
+	if w.delay > lockBackoffMax {
+		w.delay = lockBackoffMax
+	}
+	return nil
+}
@@ -1,13 +1,13 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"runtime"
-	"strconv"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -29,6 +29,12 @@ type TOMLConfig struct {
 	// 备份策略
 	Retention RetentionConfig `toml:"retention"`
 
+	// 容器自动发现
+	Discovery DiscoveryConfig `toml:"discovery"`
+
+	// 镜像仓库（备份后使用 restic copy 复制新快照）
+	Mirrors []MirrorConfig `toml:"mirrors"`
+
 	// 服务器列表
 	Servers map[string]ServerConfig `toml:"servers"`
 }
@@ -41,66 +47,147 @@ type GlobalConfig struct {
 	ParallelBackup bool `toml:"parallel_backup"`
 	// 最大并发数
 	MaxConcurrency int `toml:"max_concurrency"`
+
+	// 各阶段超时时间的默认值（秒，服务器中的同名设置优先）
+	RconTimeout   int `toml:"rcon_timeout"`
+	SaveTimeout   int `toml:"save_timeout"`
+	BackupTimeout int `toml:"backup_timeout"`
+	ForgetTimeout int `toml:"forget_timeout"`
+	// restic prune 的超时时间（秒，按仓库执行，只在 [global] 中有效）
+	PruneTimeout int `toml:"prune_timeout"`
+
+	// 其他实例正在运行时的处理方式: "skip"、"wait"（默认）或 "fail"
+	RunLock string `toml:"run_lock"`
+	// run_lock = "wait" 时的最长等待时间（分钟，默认 30）
+	RunLockWait int `toml:"run_lock_wait"`
 }
 
 // ServerConfig 单个服务器配置
 type ServerConfig struct {
+	// 服务器类型: "docker"（默认）、"rcon-only"、"systemd"、"tmux"、"screen"
+	Type          string `toml:"type"`
 	ContainerName string `toml:"container_name"`
-	WorldDir      string `toml:"world_dir"`
-	BackupTag     string `toml:"backup_tag"`
-	BackupHost    string `toml:"backup_host"`
-	Enabled       bool   `toml:"enabled"`
-	Description   string `toml:"description"`
+	// 容器运行时: "docker"（默认）、"podman"、"nerdctl"
+	Runtime     string `toml:"runtime"`
+	WorldDir    string `toml:"world_dir"`
+	BackupTag   string `toml:"backup_tag"`
+	BackupHost  string `toml:"backup_host"`
+	Enabled     bool   `toml:"enabled"`
+	Description string `toml:"description"`
+
+	// RCON 配置（设置 rcon_password 后使用原生 RCON，否则使用 docker exec rcon-cli）
+	RconHost     string `toml:"rcon_host"`
+	RconPort     int    `toml:"rcon_port"`
+	RconPassword string `toml:"rcon_password"`
+
+	// 非容器服务器配置
+	SystemdUnit string `toml:"systemd_unit"`
+	Session     string `toml:"session"`
+	// 服务器日志文件（用于确认保存完成，默认查找 logs/latest.log）
+	LogFile string `toml:"log_file"`
+
+	// 等待 save-all flush 完成的超时时间（秒，默认 60）
+	SaveTimeout int `toml:"save_timeout"`
+	// 保存超时或无法确认保存完成时的处理方式: "abort"（默认）或 "continue"
+	OnSaveTimeout string `toml:"on_save_timeout"`
+
+	// RCON 命令（save-off、save-on 等）的超时时间（秒，默认 10）
+	RconTimeout int `toml:"rcon_timeout"`
+	// restic backup 的超时时间（秒，默认 14400），超时后中止备份并恢复写入
+	BackupTimeout int `toml:"backup_timeout"`
+	// restic forget 的超时时间（秒，默认 1800，包含等待仓库锁的时间）
+	ForgetTimeout int `toml:"forget_timeout"`
+
+	// 服务器自己的保留策略（[servers.X.retention]，只覆盖填写的字段）
+	Retention RetentionConfig `toml:"retention"`
+
+	// 服务器自己的仓库和凭证（[servers.X.restic]/[servers.X.aws]，未填写的字段沿用全局值，
+	// 密码和 access_key_id/secret_access_key 作为整体沿用）
+	Restic ResticConfig `toml:"restic"`
+	AWS    AWSConfig    `toml:"aws"`
 }
 
 // AWSConfig AWS/R2 凭证配置
+// 所有字段都支持 ${ENV_VAR} 形式的环境变量引用
 type AWSConfig struct {
 	AccessKeyID     string `toml:"access_key_id"`
 	SecretAccessKey string `toml:"secret_access_key"`
-	Region          string `toml:"region"`
+	// 从文件读取 secret_access_key（相对路径在 $CREDENTIALS_DIRECTORY 中查找）
+	SecretAccessKeyFile string `toml:"secret_access_key_file"`
+	Region              string `toml:"region"`
 }
 
 // ResticConfig Restic 相关配置
+// 所有字段都支持 ${ENV_VAR} 形式的环境变量引用
 type ResticConfig struct {
 	Repository string `toml:"repository"`
 	Password   string `toml:"password"`
+	// 密码文件（以 RESTIC_PASSWORD_FILE 传给 restic，优先于 password；相对路径在 $CREDENTIALS_DIRECTORY 中查找）
+	PasswordFile string `toml:"password_file"`
+	// 输出密码的命令（以 RESTIC_PASSWORD_COMMAND 传给 restic，优先于 password_file）
+	PasswordCommand string `toml:"password_command"`
+	// 仓库被其他主机或进程锁定时的最长等待时间（秒，默认 600，只在全局 [restic] 中有效）
+	LockWait int `toml:"lock_wait"`
+	// 仓库不存在时自动执行 restic init（默认关闭，只在全局 [restic] 中有效）
+	AutoInit bool `toml:"auto_init"`
 }
 
 // RetentionConfig 快照保留策略
 type RetentionConfig struct {
+	KeepLast    int `toml:"keep_last"`
+	KeepHourly  int `toml:"keep_hourly"`
 	KeepDaily   int `toml:"keep_daily"`
 	KeepWeekly  int `toml:"keep_weekly"`
 	KeepMonthly int `toml:"keep_monthly"`
-	KeepLast    int `toml:"keep_last"`
+	KeepYearly  int `toml:"keep_yearly"`
+	// 保留最近一段时间内的所有快照，格式同 restic --keep-within（如 "7d"、"1y6m"）
+	KeepWithin string `toml:"keep_within"`
 }
 
 // Config 运行时配置（单个服务器）
 type Config struct {
+	// 服务器类型
+	Type string
+
 	// Minecraft 容器配置
 	MCContainer string
+	Runtime     string
 	WorldDir    string
 
-	// Restic 仓库配置
-	ResticRepository string
-	ResticPassword   string
-	AWSRegion        string
+	// RCON 配置
+	RconHost     string
+	RconPort     int
+	RconPassword string
+
+	// 非容器服务器配置
+	SystemdUnit string
+	Session     string
+	LogFile     string
+
+	// 保存策略
+	SaveTimeout   time.Duration
+	OnSaveTimeout string
+
+	// 各阶段的超时时间
+	RconTimeout   time.Duration
+	BackupTimeout time.Duration
+	ForgetTimeout time.Duration
+
+	// Restic 仓库及凭证
+	Repository *Repository
 
 	// 备份标签配置
 	BackupTag  string
 	BackupHost string
 
-	// 快照保留策略
-	KeepDaily   int
-	KeepWeekly  int
-	KeepMonthly int
-	KeepLast    int
+	// 快照保留策略（全局策略与服务器策略合并后的结果）
+	Retention RetentionConfig
 
 	// 配置文件路径
 	ConfigFile string
 
-	// AWS 凭证
-	AWSAccessKeyID     string
-	AWSSecretAccessKey string
+	// 是否在配置文件中启用
+	Enabled bool
 }
 
 // MultiServerConfig 多服务器运行时配置
@@ -112,25 +199,59 @@ type MultiServerConfig struct {
 	ParallelBackup bool
 	MaxConcurrency int
 
-	// AWS 凭证和 Restic 配置（所有服务器共享）
-	AWSAccessKeyID     string
-	AWSSecretAccessKey string
-	AWSRegion          string
-	ResticRepository   string
-	ResticPassword     string
+	// 全局 Restic 仓库及凭证（未单独配置仓库的服务器使用）
+	Repository *Repository
 
-	// 快照保留策略（所有服务器共享）
-	KeepDaily   int
-	KeepWeekly  int
-	KeepMonthly int
-	KeepLast    int
+	// 全局快照保留策略
+	Retention RetentionConfig
 
-	// 服务器列表
+	// restic prune 的超时时间
+	PruneTimeout time.Duration
+
+	// 运行锁被其他实例持有时的处理方式和最长等待时间
+	RunLock     string
+	RunLockWait time.Duration
+
+	// 镜像仓库
+	Mirrors []*Mirror
+
+	// 服务器列表（包含未启用的服务器，由 selectServers 过滤）
 	Servers map[string]*Config
+
+	// 过滤之前配置文件中的所有服务器（恢复写入时使用，不受 --server 限制）
+	AllServers map[string]*Config
+}
+
+// on_save_timeout 可选值
+const (
+	saveTimeoutAbort    = "abort"
+	saveTimeoutContinue = "continue"
+)
+
+// 各阶段超时时间的默认值（RCON 命令的默认值见 defaultRconTimeout）
+const (
+	defaultSaveTimeout   = 60 * time.Second
+	defaultBackupTimeout = 4 * time.Hour
+	defaultForgetTimeout = 30 * time.Minute
+	defaultPruneTimeout  = 4 * time.Hour
+)
+
+// phaseTimeout 返回某个阶段的超时时间：优先使用服务器的设置，其次是 [global] 中的设置，都未设置时使用默认值
+func phaseTimeout(server, global int, fallback time.Duration) time.Duration {
+	switch {
+	case server > 0:
+		return time.Duration(server) * time.Second
+	case global > 0:
+		return time.Duration(global) * time.Second
+	default:
+		return fallback
+	}
 }
 
 // Logger 结构体
 type Logger struct {
+	// 是否输出调试日志
+	Verbose bool
 }
 
 // NewLogger 创建新的日志记录器
@@ -145,6 +266,13 @@ func (l *Logger) Log(format string, args ...interface{}) {
 	fmt.Printf("[%s] %s\n", timestamp, message)
 }
 
+// Debug 记录调试日志（仅在 --verbose 时输出）
+func (l *Logger) Debug(format string, args ...interface{}) {
+	if l.Verbose {
+		l.Log(format, args...)
+	}
+}
+
 // 全局日志实例
 var logger = NewLogger()
 
@@ -212,7 +340,7 @@ repository = "s3:https://your-account-id.r2.cloudflarestorage.com/minecraft-back
 password = "your_restic_repository_password_here"
 
 [retention]
-# 快照保留策略（适用于所有服务器）
+# 快照保留策略（适用于所有服务器，可在 [servers.服务器名称.retention] 中覆盖）
 keep_daily = 9      # 保留每日快照数量
 keep_weekly = 14    # 保留每周快照数量
 keep_monthly = 8    # 保留每月快照数量
@@ -301,8 +429,17 @@ enabled = true
 	return nil
 }
 
+// expandHome 展开路径开头的 ~/
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		homeDir, _ := os.UserHomeDir()
+		return filepath.Join(homeDir, path[2:])
+	}
+	return path
+}
+
 // loadConfig 加载配置文件并解析为多服务器配置
-func loadConfig(configPath string) (*MultiServerConfig, error) {
+func loadConfig(ctx context.Context, configPath string) (*MultiServerConfig, error) {
 	// 检查配置文件是否存在
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
 		return nil, fmt.Errorf("配置文件不存在: %s", configPath)
@@ -319,18 +456,24 @@ func loadConfig(configPath string) (*MultiServerConfig, error) {
 		return nil, fmt.Errorf("%s 不是常规文件", configPath)
 	}
 
-	// 检查权限（应该是 600）
-	if info.Mode().Perm() != 0600 {
-		logger.Log("警告: %s 权限不安全 (%o)，建议设置为 600", configPath, info.Mode().Perm())
-		logger.Log("执行: chmod 600 %s", configPath)
-	}
-
 	// 读取 TOML 配置
 	var tomlConfig TOMLConfig
-	if _, err := toml.DecodeFile(configPath, &tomlConfig); err != nil {
+	meta, err := toml.DecodeFile(configPath, &tomlConfig)
+	if err != nil {
 		return nil, fmt.Errorf("解析配置文件失败: %v", err)
 	}
 
+	// 配置文件中直接写有密码时检查权限（应该是 600）
+	if hasPlaintextSecrets(&tomlConfig) && info.Mode().Perm() != 0600 {
+		logger.Log("警告: %s 包含明文密码且权限不安全 (%o)，建议设置为 600", configPath, info.Mode().Perm())
+		logger.Log("执行: chmod 600 %s", configPath)
+	}
+
+	// 解析凭证（环境变量引用、凭证文件、systemd 凭证目录）
+	if err := resolveSecrets(&tomlConfig); err != nil {
+		return nil, fmt.Errorf("解析凭证失败: %v", err)
+	}
+
 	// 获取默认主机名
 	defaultHost := tomlConfig.Global.DefaultBackupHost
 	if defaultHost == "" {
@@ -341,37 +484,62 @@ func loadConfig(configPath string) (*MultiServerConfig, error) {
 	if tomlConfig.Global.MaxConcurrency <= 0 {
 		tomlConfig.Global.MaxConcurrency = 2
 	}
+	if tomlConfig.Global.RunLock == "" {
+		tomlConfig.Global.RunLock = runLockWait
+	}
+	lockWait := defaultRunLockWait
+	if tomlConfig.Global.RunLockWait > 0 {
+		lockWait = time.Duration(tomlConfig.Global.RunLockWait) * time.Minute
+	}
 
 	// 转换为多服务器运行时配置
 	multiConfig := &MultiServerConfig{
-		ConfigFile:         configPath,
-		ParallelBackup:     tomlConfig.Global.ParallelBackup,
-		MaxConcurrency:     tomlConfig.Global.MaxConcurrency,
-		AWSAccessKeyID:     tomlConfig.AWS.AccessKeyID,
-		AWSSecretAccessKey: tomlConfig.AWS.SecretAccessKey,
-		AWSRegion:          tomlConfig.AWS.Region,
-		ResticRepository:   tomlConfig.Restic.Repository,
-		ResticPassword:     tomlConfig.Restic.Password,
-		KeepDaily:          tomlConfig.Retention.KeepDaily,
-		KeepWeekly:         tomlConfig.Retention.KeepWeekly,
-		KeepMonthly:        tomlConfig.Retention.KeepMonthly,
-		KeepLast:           tomlConfig.Retention.KeepLast,
-		Servers:            make(map[string]*Config),
+		ConfigFile:     configPath,
+		ParallelBackup: tomlConfig.Global.ParallelBackup,
+		MaxConcurrency: tomlConfig.Global.MaxConcurrency,
+		Repository:     newRepository(tomlConfig.Restic, ResticConfig{}, tomlConfig.AWS, AWSConfig{}),
+		Retention:      tomlConfig.Retention,
+		PruneTimeout:   phaseTimeout(0, tomlConfig.Global.PruneTimeout, defaultPruneTimeout),
+		RunLock:        tomlConfig.Global.RunLock,
+		RunLockWait:    lockWait,
+		Servers:        make(map[string]*Config),
+	}
+
+	// 合并自动发现的服务器
+	// 容器运行时不可用时只记录警告：配置文件中的服务器仍然可以恢复写入、查看快照和恢复
+	var discoveryErr error
+	if tomlConfig.Discovery.Enabled {
+		discovered, err := discoverServers(ctx, tomlConfig.Discovery)
+		if err != nil {
+			discoveryErr = err
+			logger.Log("警告: 容器自动发现失败，只使用配置文件中的服务器: %v", err)
+		} else {
+			tomlConfig.Servers = mergeDiscoveredServers(tomlConfig.Servers, discovered)
+		}
 	}
 
 	// 检查是否有服务器配置
 	if len(tomlConfig.Servers) == 0 {
+		if discoveryErr != nil {
+			return nil, fmt.Errorf("配置文件中没有服务器，且容器自动发现失败: %v", discoveryErr)
+		}
 		return nil, fmt.Errorf("配置文件中未找到任何服务器配置")
 	}
 
-	// 转换服务器配置
-	for serverName, serverConfig := range tomlConfig.Servers {
-		// 跳过未启用的服务器
-		if !serverConfig.Enabled {
-			logger.Log("跳过未启用的服务器: %s", serverName)
-			continue
+	// 镜像仓库
+	for i, mirrorConfig := range tomlConfig.Mirrors {
+		mirror, err := newMirror(i, mirrorConfig, tomlConfig.Restic, tomlConfig.AWS, tomlConfig.Retention)
+		if err != nil {
+			return nil, err
 		}
+		multiConfig.Mirrors = append(multiConfig.Mirrors, mirror)
+	}
 
+	// 地址和凭证相同的服务器共用同一个仓库
+	repositories := map[string]*Repository{multiConfig.Repository.key(): multiConfig.Repository}
+
+	// 转换服务器配置
+	for serverName, serverConfig := range tomlConfig.Servers {
 		// 设置备份主机（如果未设置则使用默认值）
 		backupHost := serverConfig.BackupHost
 		if backupHost == "" {
@@ -379,61 +547,136 @@ func loadConfig(configPath string) (*MultiServerConfig, error) {
 		}
 
 		// 展开环境变量（如果路径中包含 ~）
-		worldDir := serverConfig.WorldDir
-		if strings.HasPrefix(worldDir, "~/") {
-			homeDir, _ := os.UserHomeDir()
-			worldDir = filepath.Join(homeDir, worldDir[2:])
+		// container:/path 形式的容器内路径在备份、恢复各服务器时由 resolveWorldDir 解析
+		worldDir := expandHome(serverConfig.WorldDir)
+
+		// 保存策略默认值
+		if serverConfig.OnSaveTimeout == "" {
+			serverConfig.OnSaveTimeout = saveTimeoutAbort
+		}
+
+		repo := newRepository(tomlConfig.Restic, serverConfig.Restic, tomlConfig.AWS, serverConfig.AWS)
+		if existing, ok := repositories[repo.key()]; ok {
+			repo = existing
+		} else {
+			repositories[repo.key()] = repo
 		}
 
 		config := &Config{
-			ConfigFile:         configPath,
-			MCContainer:        serverConfig.ContainerName,
-			WorldDir:           worldDir,
-			BackupTag:          serverConfig.BackupTag,
-			BackupHost:         backupHost,
-			AWSAccessKeyID:     multiConfig.AWSAccessKeyID,
-			AWSSecretAccessKey: multiConfig.AWSSecretAccessKey,
-			AWSRegion:          multiConfig.AWSRegion,
-			ResticRepository:   multiConfig.ResticRepository,
-			ResticPassword:     multiConfig.ResticPassword,
-			KeepDaily:          multiConfig.KeepDaily,
-			KeepWeekly:         multiConfig.KeepWeekly,
-			KeepMonthly:        multiConfig.KeepMonthly,
-			KeepLast:           multiConfig.KeepLast,
+			ConfigFile:    configPath,
+			Enabled:       serverConfig.Enabled,
+			Type:          serverConfig.Type,
+			MCContainer:   serverConfig.ContainerName,
+			Runtime:       serverConfig.Runtime,
+			WorldDir:      worldDir,
+			RconHost:      serverConfig.RconHost,
+			RconPort:      serverConfig.RconPort,
+			RconPassword:  serverConfig.RconPassword,
+			SystemdUnit:   serverConfig.SystemdUnit,
+			Session:       serverConfig.Session,
+			LogFile:       expandHome(serverConfig.LogFile),
+			SaveTimeout:   phaseTimeout(serverConfig.SaveTimeout, tomlConfig.Global.SaveTimeout, defaultSaveTimeout),
+			OnSaveTimeout: serverConfig.OnSaveTimeout,
+			RconTimeout:   phaseTimeout(serverConfig.RconTimeout, tomlConfig.Global.RconTimeout, defaultRconTimeout),
+			BackupTimeout: phaseTimeout(serverConfig.BackupTimeout, tomlConfig.Global.BackupTimeout, defaultBackupTimeout),
+			ForgetTimeout: phaseTimeout(serverConfig.ForgetTimeout, tomlConfig.Global.ForgetTimeout, defaultForgetTimeout),
+			BackupTag:     serverConfig.BackupTag,
+			BackupHost:    backupHost,
+			Repository:    repo,
+			Retention:     mergeRetention(multiConfig.Retention, serverConfig.Retention, retentionKeysDefined(meta, serverName)),
 		}
 
 		multiConfig.Servers[serverName] = config
 	}
 
-	// 检查是否有启用的服务器
-	if len(multiConfig.Servers) == 0 {
-		return nil, fmt.Errorf("没有启用的服务器，请在配置文件中将需要备份的服务器设置为 enabled = true")
-	}
-
-	// 设置环境变量（所有服务器共享）
-	os.Setenv("AWS_ACCESS_KEY_ID", multiConfig.AWSAccessKeyID)
-	os.Setenv("AWS_SECRET_ACCESS_KEY", multiConfig.AWSSecretAccessKey)
-	os.Setenv("AWS_DEFAULT_REGION", multiConfig.AWSRegion)
-	os.Setenv("RESTIC_REPOSITORY", multiConfig.ResticRepository)
-	os.Setenv("RESTIC_PASSWORD", multiConfig.ResticPassword)
-
 	return multiConfig, nil
 }
 
-// checkDependencies 检查系统依赖
-func checkDependencies() error {
-	// 检查 Docker 服务
-	if runtime.GOOS == "linux" {
-		cmd := exec.Command("systemctl", "is-active", "--quiet", "docker")
-		if err := cmd.Run(); err != nil {
-			logger.Log("错误: Docker 服务未运行")
-			logger.Log("请启动Docker服务: sudo systemctl start docker")
-			return fmt.Errorf("docker 服务未运行")
+// validateConfig 校验配置内容，返回发现的问题列表
+func validateConfig(multiConfig *MultiServerConfig) []string {
+	var problems []string
+
+	switch multiConfig.RunLock {
+	case runLockSkip, runLockWait, runLockFail:
+	default:
+		problems = append(problems, fmt.Sprintf("[global] run_lock 无效: %s（可选 skip、wait、fail）", multiConfig.RunLock))
+	}
+
+	tags := make(map[string]string)
+	for serverName, config := range multiConfig.Servers {
+		switch config.Type {
+		case "", serverTypeDocker:
+			if config.MCContainer == "" {
+				problems = append(problems, fmt.Sprintf("[servers.%s] 未设置 container_name", serverName))
+			}
+			if _, err := newContainerRuntime(config.Runtime); err != nil {
+				problems = append(problems, fmt.Sprintf("[servers.%s] %v", serverName, err))
+			}
+		case serverTypeRcon, serverTypeSystemd:
+			if config.Type == serverTypeSystemd && config.SystemdUnit == "" {
+				problems = append(problems, fmt.Sprintf("[servers.%s] 未设置 systemd_unit", serverName))
+			}
+			if !useNativeRcon(config) {
+				problems = append(problems, fmt.Sprintf("[servers.%s] %s 类型的服务器需要设置 rcon_password", serverName, config.Type))
+			}
+		case serverTypeTmux, serverTypeScreen:
+			if config.Session == "" {
+				problems = append(problems, fmt.Sprintf("[servers.%s] 未设置 session", serverName))
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("[servers.%s] type 无效: %s（可选 docker、rcon-only、systemd、tmux、screen）", serverName, config.Type))
+		}
+		if config.Repository.hasPartialAWSCredentials() {
+			problems = append(problems, fmt.Sprintf("[servers.%s] AWS 凭证不完整: access_key_id 和 secret_access_key 需要同时填写（[aws] 或 [servers.%s.aws]）", serverName, serverName))
+		}
+		if config.Repository.URL == "" {
+			problems = append(problems, fmt.Sprintf("[servers.%s] 未设置 restic 仓库（[restic] 或 [servers.%s.restic] 的 repository）", serverName, serverName))
+		}
+		if !config.Repository.hasPassword() {
+			problems = append(problems, fmt.Sprintf("[servers.%s] 未设置 restic 仓库密码（[restic] 或 [servers.%s.restic] 的 password、password_file 或 password_command）", serverName, serverName))
+		} else if file := config.Repository.PasswordFile; file != "" && config.Repository.PasswordCommand == "" {
+			if _, err := os.Stat(file); err != nil {
+				problems = append(problems, fmt.Sprintf("[servers.%s] restic 密码文件不可访问: %v", serverName, err))
+			}
+		}
+		if config.BackupTag == "" {
+			problems = append(problems, fmt.Sprintf("[servers.%s] 未设置 backup_tag", serverName))
+		} else if other, ok := tags[config.BackupTag]; ok {
+			problems = append(problems, fmt.Sprintf("[servers.%s] backup_tag 与 [servers.%s] 重复: %s", serverName, other, config.BackupTag))
+		} else {
+			tags[config.BackupTag] = serverName
+		}
+		if config.OnSaveTimeout != saveTimeoutAbort && config.OnSaveTimeout != saveTimeoutContinue {
+			problems = append(problems, fmt.Sprintf("[servers.%s] on_save_timeout 无效: %s（可选 abort、continue）", serverName, config.OnSaveTimeout))
+		}
+		if config.RconPort < 0 || config.RconPort > 65535 {
+			problems = append(problems, fmt.Sprintf("[servers.%s] rcon_port 无效: %d", serverName, config.RconPort))
+		}
+		if err := config.Retention.validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("[servers.%s] 保留策略无效: %v", serverName, err))
+		}
+		if config.WorldDir == "" {
+			problems = append(problems, fmt.Sprintf("[servers.%s] 未设置 world_dir", serverName))
+		} else if containerPath, err := containerWorldPath(config); err != nil {
+			problems = append(problems, fmt.Sprintf("[servers.%s] %v", serverName, err))
+		} else if containerPath == "" {
+			// 容器内路径在使用时才解析，由 check 检查能否解析
+			if info, err := os.Stat(config.WorldDir); err != nil {
+				problems = append(problems, fmt.Sprintf("[servers.%s] world_dir 不可访问: %v", serverName, err))
+			} else if !info.IsDir() {
+				problems = append(problems, fmt.Sprintf("[servers.%s] world_dir 不是目录: %s", serverName, config.WorldDir))
+			}
 		}
 	}
 
-	// 检查必要命令
-	commands := []string{"docker", "restic"}
+	problems = append(problems, validateMirrors(multiConfig)...)
+
+	sort.Strings(problems)
+	return problems
+}
+
+// checkDependencies 检查系统依赖
+func checkDependencies(commands ...string) error {
 	var missingDeps []string
 
 	for _, cmd := range commands {
@@ -450,15 +693,6 @@ func checkDependencies() error {
 	return nil
 }
 
-// checkNetwork 检查网络连接
-func checkNetwork() {
-	logger.Log("检查网络连接...")
-	cmd := exec.Command("ping", "-c", "1", "-W", "5", "1.1.1.1")
-	if err := cmd.Run(); err != nil {
-		logger.Log("警告: 网络连接可能有问题")
-	}
-}
-
 // showConfig 显示当前配置
 func showConfig(multiConfig *MultiServerConfig) {
 	logger.Log("多服务器备份配置：")
@@ -467,133 +701,141 @@ func showConfig(multiConfig *MultiServerConfig) {
 	if multiConfig.ParallelBackup {
 		logger.Log("  最大并发: %d", multiConfig.MaxConcurrency)
 	}
-	logger.Log("  保留策略:")
-	logger.Log("    - 每日快照: %d 个", multiConfig.KeepDaily)
-	logger.Log("    - 每周快照: %d 个", multiConfig.KeepWeekly)
-	logger.Log("    - 每月快照: %d 个", multiConfig.KeepMonthly)
-	logger.Log("    - 最近快照: %d 个", multiConfig.KeepLast)
-	repoDisplay := multiConfig.ResticRepository
-	if len(repoDisplay) > 50 {
-		repoDisplay = repoDisplay[:50] + "..."
+	logger.Log("  保留策略: %s", multiConfig.Retention)
+	logger.Log("  仓库地址: %s", multiConfig.Repository)
+	for _, mirror := range multiConfig.Mirrors {
+		logger.Log("  镜像仓库: %s (%s)，保留策略: %s", mirror.Name, mirror.Repository, mirror.Retention)
 	}
-	logger.Log("  仓库地址: %s", repoDisplay)
 	logger.Log("")
 
 	logger.Log("启用的服务器列表：")
 	for serverName, config := range multiConfig.Servers {
 		logger.Log("  [%s]", serverName)
-		logger.Log("    容器名称: %s", config.MCContainer)
+		if isContainerServer(config) {
+			logger.Log("    容器名称: %s", config.MCContainer)
+			if config.Runtime != "" && config.Runtime != runtimeDocker {
+				logger.Log("    容器运行时: %s", config.Runtime)
+			}
+		} else if controller, err := controllerFor(config); err == nil {
+			logger.Log("    服务器: %s", controller)
+		}
 		logger.Log("    世界目录: %s", config.WorldDir)
 		logger.Log("    备份标签: %s", config.BackupTag)
 		logger.Log("    主机标识: %s", config.BackupHost)
+		if useNativeRcon(config) {
+			logger.Log("    RCON 地址: %s", rconAddress(config))
+		}
+		if config.Repository != multiConfig.Repository {
+			logger.Log("    仓库地址: %s", config.Repository)
+		}
+		if config.Retention != multiConfig.Retention {
+			logger.Log("    保留策略: %s", config.Retention)
+		}
+		logger.Log("    超时时间: RCON %s，保存 %s，备份 %s，清理 %s",
+			config.RconTimeout, config.SaveTimeout, config.BackupTimeout, config.ForgetTimeout)
 		logger.Log("")
 	}
 }
 
-// checkContainerRunning 检查容器是否运行
-func checkContainerRunning(containerName string) error {
-	cmd := exec.Command("docker", "ps", "--format", "{{.Names}}")
-	output, err := cmd.Output()
+// checkContainerRunning 检查服务器容器是否运行
+func checkContainerRunning(ctx context.Context, config *Config) error {
+	rt, err := runtimeFor(config)
 	if err != nil {
 		return err
 	}
 
-	containers := strings.Split(strings.TrimSpace(string(output)), "\n")
-	for _, container := range containers {
-		if container == containerName {
-			return nil
+	containerName := config.MCContainer
+	info, err := rt.InspectContainer(ctx, containerName)
+	if err != nil && !isNotFoundError(err) {
+		return err
+	}
+	if err == nil && info.State.Running {
+		switch health := info.HealthStatus(); health {
+		case "", "healthy":
+		default:
+			logger.Log("警告: 容器 %s 健康状态为 %s", containerName, health)
 		}
+		return nil
+	}
+
+	if err != nil {
+		logger.Log("错误: 容器 %s 不存在", containerName)
+	} else {
+		logger.Log("错误: 容器 %s 未运行（状态: %s）", containerName, info.State.Status)
 	}
 
-	logger.Log("错误: 容器 %s 未运行", containerName)
 	logger.Log("可用容器:")
-	cmd = exec.Command("docker", "ps", "--format", "table {{.Names}}\t{{.Status}}")
-	output, _ = cmd.Output()
-	fmt.Print(string(output))
+	if containers, err := rt.ListContainers(ctx, false); err == nil {
+		for _, container := range containers {
+			logger.Log("  %s\t%s", container.Name, container.Status)
+		}
+	}
 
 	return fmt.Errorf("container %s not running", containerName)
 }
 
-// execDockerCommand 执行 Docker 命令
-func execDockerCommand(container string, args ...string) error {
-	cmdArgs := append([]string{"exec", container}, args...)
-	cmd := exec.Command("docker", cmdArgs...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
-
-// getSnapshotCount 获取快照数量
-func getSnapshotCount() (int, error) {
-	cmd := exec.Command("restic", "snapshots", "--json", "--no-lock")
-	output, err := cmd.Output()
+// runServerCommand 向服务器发送命令并记录响应
+func runServerCommand(ctx context.Context, serverName string, config *Config, command string) error {
+	response, err := sendServerCommand(ctx, config, command, config.RconTimeout)
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	var snapshots []interface{}
-	if err := json.Unmarshal(output, &snapshots); err != nil {
-		return 0, err
+	for _, line := range strings.Split(strings.TrimSpace(response), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			logger.Log("[%s] %s: %s", serverName, command, line)
+		}
 	}
-
-	return len(snapshots), nil
+	return nil
 }
 
 // checkRepositoryConnection 检查仓库连接
-func checkRepositoryConnection() error {
+func checkRepositoryConnection(ctx context.Context, repo *Repository) error {
 	maxAttempts := 3
+	waiter := newLockWaiter(repo)
 
 	for attempt := 1; attempt <= maxAttempts; attempt++ {
 		logger.Log("尝试连接仓库 (第 %d/%d 次)...", attempt, maxAttempts)
 
-		cmd := exec.Command("restic", "snapshots")
+		cmd := repo.command(ctx, "snapshots")
 		output, err := cmd.CombinedOutput()
 
 		if err == nil {
 			logger.Log("仓库连接成功")
 			return nil
 		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
 		outputStr := string(output)
 
-		// 检查是否是锁定问题
-		if strings.Contains(outputStr, "repository is already locked") {
-			logger.Log("检测到仓库锁定问题，尝试解锁...")
-
-			// 显示锁定信息
-			lines := strings.Split(outputStr, "\n")
-			for _, line := range lines {
-				if strings.Contains(line, "locked by") || strings.Contains(line, "lock was created") || strings.Contains(line, "storage ID") {
-					logger.Log("  %s", line)
-				}
+		// 仓库被锁定：删除过期的锁，或等待其他进程释放，等待不计入重试次数
+		if isRepositoryLocked(outputStr) || resticExitCode(err) == resticExitLockFailed {
+			logger.Log("检测到仓库锁定，检查锁的持有者...")
+			if err := waiter.wait(ctx); err != nil {
+				return err
 			}
-
-			// 尝试解锁
-			unlockCmd := exec.Command("restic", "unlock")
-			if err := unlockCmd.Run(); err == nil {
-				logger.Log("仓库解锁成功")
-				time.Sleep(2 * time.Second)
-			} else {
-				logger.Log("警告: 自动解锁失败")
-				if attempt == maxAttempts {
-					logger.Log("错误: 无法解锁仓库，请手动执行: restic unlock")
-					return fmt.Errorf("repository locked")
-				}
+			attempt--
+			continue
+		} else if resticExitCode(err) == resticExitRepositoryMissing {
+			// 仓库地址写错（如存储桶名称）时也会得到这个结果，默认不自动初始化
+			if !repo.AutoInit {
+				logger.Log("错误: 仓库 %s 不存在", repo)
+				logger.Log("  请确认仓库地址（如存储桶名称）是否正确；")
+				logger.Log("  新仓库请先执行 minecraft-backup init，或在 [restic] 中设置 auto_init = true")
+				return fmt.Errorf("repository does not exist")
 			}
-		} else if strings.Contains(outputStr, "Is there a repository") {
-			// 仓库不存在，尝试初始化
-			logger.Log("仓库不存在，尝试初始化...")
-			initCmd := exec.Command("restic", "init")
-			if err := initCmd.Run(); err == nil {
-				logger.Log("仓库初始化成功")
-				return nil
-			} else {
+			logger.Log("仓库不存在，已启用 auto_init，开始初始化...")
+			if err := initRepository(ctx, repo, nil); err != nil {
 				logger.Log("错误: 仓库初始化失败，请检查：")
 				logger.Log("  1. R2 凭证是否正确")
 				logger.Log("  2. 存储桶是否存在")
 				logger.Log("  3. 网络连接是否正常")
-				return fmt.Errorf("repository initialization failed")
+				return fmt.Errorf("repository initialization failed: %v", err)
 			}
+			logger.Log("仓库初始化成功")
+			return nil
 		} else {
 			logger.Log("连接失败，错误信息：")
 			lines := strings.Split(outputStr, "\n")
@@ -609,174 +851,208 @@ func checkRepositoryConnection() error {
 			}
 		}
 
-		time.Sleep(3 * time.Second)
+		if err := sleepContext(ctx, 3*time.Second); err != nil {
+			return err
+		}
 	}
 
 	return fmt.Errorf("failed to connect to repository")
 }
 
-// waitForSaveCompletion 等待保存完成
-func waitForSaveCompletion(container string, worldDir string) {
-	logger.Log("等待世界保存完成...")
-	maxAttempts := 30
+// saveCompleteMarkers 表示世界已保存完成的输出
+var saveCompleteMarkers = []string{"Saved the game", "Saved the world"}
+
+// isSaveComplete 判断命令响应或日志中是否包含保存完成信息
+func isSaveComplete(output string) bool {
+	for _, marker := range saveCompleteMarkers {
+		if strings.Contains(output, marker) {
+			return true
+		}
+	}
+	return strings.Contains(output, "ThreadedAnvilChunkStorage") && strings.Contains(output, "Saved")
+}
+
+// saveWorld 执行 save-all flush 并确认世界已完整写入磁盘
+// 超时或无法确认时按 on_save_timeout 策略处理
+func saveWorld(ctx context.Context, serverName string, config *Config) error {
+	logger.Log("[%s] 保存世界 (save-all flush)...", serverName)
 	startTime := time.Now()
+	deadline := startTime.Add(config.SaveTimeout)
 
-	for attempt := 0; attempt < maxAttempts; attempt++ {
-		// 获取最近的日志
-		cmd := exec.Command("docker", "logs", "--since", startTime.Format(time.RFC3339), container)
-		output, _ := cmd.Output()
-		logs := string(output)
+	// 发送命令前开始监视服务器输出，用于响应中没有保存结果时确认
+	var watch outputWatcher
+	if controller, err := controllerFor(config); err == nil {
+		watch, _ = controller.WatchOutput()
+	}
 
-		// 检查保存完成信息
-		if strings.Contains(logs, "Saved the game") ||
-			strings.Contains(logs, "Saved the world") ||
-			strings.Contains(logs, "ThreadedAnvilChunkStorage") && strings.Contains(logs, "Saved") {
-			logger.Log("检测到保存完成日志")
-			return
+	// save-all flush 在服务端同步执行，保存完成后才返回响应
+	response, err := sendServerCommand(ctx, config, "save-all flush", config.SaveTimeout)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil && !isTimeoutError(err) {
+		return fmt.Errorf("无法执行 save-all 命令: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(response), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			logger.Log("[%s] save-all flush: %s", serverName, line)
 		}
+	}
 
-		time.Sleep(2 * time.Second)
-		logger.Log("等待保存完成... (%d/%d)", attempt+1, maxAttempts)
+	if err == nil && isSaveComplete(response) {
+		logger.Log("[%s] 世界保存完成，耗时 %s", serverName, time.Since(startTime).Round(time.Millisecond))
+		return nil
 	}
 
-	logger.Log("警告: 未检测到明确的保存完成信号，但继续备份")
-}
+	// 部分服务端（以及通过按键注入发送的命令）不在响应中返回保存结果，退回到检查服务器日志
+	if err == nil && watch != nil && waitForSaveCompletion(ctx, watch, deadline) {
+		logger.Log("[%s] 世界保存完成，耗时 %s", serverName, time.Since(startTime).Round(time.Millisecond))
+		return nil
+	}
 
-// performBackup 执行备份
-func performBackup(config *Config) error {
-	logger.Log("开始增量备份...")
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 
-	// 记录备份前的快照数量
-	snapshotCountBefore, _ := getSnapshotCount()
+	reason := "未收到保存完成响应"
+	if err != nil {
+		reason = fmt.Sprintf("等待保存完成超时 (%s)", config.SaveTimeout)
+	}
 
-	// 执行备份
-	cmd := exec.Command("restic", "backup",
-		"--verbose",
-		"--host", config.BackupHost,
-		"--tag", config.BackupTag,
-		config.WorldDir)
+	if config.OnSaveTimeout == saveTimeoutContinue {
+		logger.Log("警告: 服务器 %s %s，按 on_save_timeout = \"continue\" 继续备份", serverName, reason)
+		return nil
+	}
+	return fmt.Errorf("%s，已放弃本次备份（on_save_timeout = \"abort\"）", reason)
+}
 
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// waitForSaveCompletion 在服务器日志中等待保存完成信息
+func waitForSaveCompletion(ctx context.Context, watch outputWatcher, deadline time.Time) bool {
+	logger.Log("等待世界保存完成...")
 
-	if err := cmd.Run(); err != nil {
-		logger.Log("备份失败")
-		return err
-	}
+	for time.Now().Before(deadline) {
+		// 获取最近的日志
+		logs, err := watch(ctx)
+		if err != nil {
+			logger.Debug("获取服务器日志失败: %v", err)
+		}
 
-	// 验证备份
-	snapshotCountAfter, _ := getSnapshotCount()
+		// 检查保存完成信息
+		if isSaveComplete(logs) {
+			logger.Log("检测到保存完成日志")
+			return true
+		}
 
-	if snapshotCountAfter > snapshotCountBefore {
-		logger.Log("备份成功完成，新增快照 %d 个", snapshotCountAfter-snapshotCountBefore)
-	} else {
-		logger.Log("警告: 备份命令成功但未检测到新快照")
+		if sleepContext(ctx, 2*time.Second) != nil {
+			return false
+		}
 	}
 
-	return nil
+	return false
 }
 
 // 显示最新快照信息
-func getLatestSnapshotInfo() {
-	logger.Log("最新快照信息:")
-	cmd := exec.Command("restic", "snapshots", "--latest", "1", "--compact", "--no-lock")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Run()
+func getLatestSnapshotInfo(ctx context.Context, multiConfig *MultiServerConfig) {
+	for _, repo := range repositoriesOf(multiConfig) {
+		logger.Log("最新快照信息 (%s):", repo)
+		cmd := repo.command(ctx, "snapshots", "--latest", "1", "--compact", "--no-lock")
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Run()
+	}
 }
 
 // backupSingleServer 备份单个服务器
-func backupSingleServer(serverName string, config *Config) error {
+// 执行过 save-off 的服务器无论备份成功、失败还是被中断都会通过 guard 恢复写入
+func backupSingleServer(ctx context.Context, serverName string, config *Config, guard *saveGuard) (*BackupResult, error) {
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("服务器 %s: 备份已中断", serverName)
+	}
 	logger.Log("开始备份服务器: %s", serverName)
 
-	// 检查容器是否运行
-	if err := checkContainerRunning(config.MCContainer); err != nil {
-		return fmt.Errorf("服务器 %s: %v", serverName, err)
+	// 检查服务器是否运行
+	if err := checkServerRunning(ctx, config); err != nil {
+		return nil, fmt.Errorf("服务器 %s: %v", serverName, err)
+	}
+	if err := resolveWorldDir(ctx, serverName, config); err != nil {
+		return nil, fmt.Errorf("服务器 %s: %v", serverName, err)
+	}
+
+	// 本地仓库先检查剩余空间，避免暂停写入后才发现放不下
+	if err := checkWorldFitsRepository(serverName, config); err != nil {
+		return nil, fmt.Errorf("服务器 %s: %v", serverName, err)
 	}
 
-	// 设置清理函数
-	saveOnExecuted := false
-	defer func() {
-		cleanup(config.MCContainer, saveOnExecuted)
-	}()
+	// 失败或中断时恢复写入（已经恢复时不会重复执行）
+	defer guard.restore(ctx, serverName)
 
 	// 暂停写入
-	logger.Log("[%s] 暂停 Minecraft 世界写入...", serverName)
-	if err := execDockerCommand(config.MCContainer, "rcon-cli", "save-off"); err != nil {
-		return fmt.Errorf("服务器 %s: 无法执行 save-off 命令: %v", serverName, err)
+	if err := guard.disable(ctx, serverName, config); err != nil {
+		return nil, fmt.Errorf("服务器 %s: 无法执行 save-off 命令: %v", serverName, err)
 	}
 
-	if err := execDockerCommand(config.MCContainer, "rcon-cli", "save-all"); err != nil {
-		return fmt.Errorf("服务器 %s: 无法执行 save-all 命令: %v", serverName, err)
+	// 保存世界并等待写入完成
+	if err := saveWorld(ctx, serverName, config); err != nil {
+		return nil, fmt.Errorf("服务器 %s: %v", serverName, err)
 	}
 
-	// 等待保存完成
-	waitForSaveCompletion(config.MCContainer, config.WorldDir)
-
 	// 执行备份
-	if err := performBackup(config); err != nil {
-		return fmt.Errorf("服务器 %s: 备份失败: %v", serverName, err)
+	result, err := performBackup(ctx, serverName, config)
+	if err != nil {
+		return nil, fmt.Errorf("服务器 %s: 备份失败: %v", serverName, err)
 	}
 
 	// 恢复写入
-	logger.Log("[%s] 恢复 Minecraft 世界写入...", serverName)
-	if err := execDockerCommand(config.MCContainer, "rcon-cli", "save-on"); err != nil {
-		logger.Log("警告: 服务器 %s 无法执行 save-on 命令，请手动检查", serverName)
-	} else {
-		saveOnExecuted = true
-	}
+	guard.restore(ctx, serverName)
 
 	logger.Log("[%s] 服务器备份完成", serverName)
-	return nil
+	return result, nil
 }
 
-// backupAllServers 备份所有启用的服务器
-func backupAllServers(multiConfig *MultiServerConfig) error {
+// backupAllServers 备份所有启用的服务器，返回备份成功的服务器的结果（按服务器名称排序）
+func backupAllServers(ctx context.Context, multiConfig *MultiServerConfig, guard *saveGuard) ([]*BackupResult, error) {
 	if multiConfig.ParallelBackup {
-		return backupServersParallel(multiConfig)
+		return backupServersParallel(ctx, multiConfig, guard)
 	} else {
-		return backupServersSequential(multiConfig)
+		return backupServersSequential(ctx, multiConfig, guard)
 	}
 }
 
 // backupServersSequential 顺序备份所有服务器
-func backupServersSequential(multiConfig *MultiServerConfig) error {
-	var failedServers []string
-	successCount := 0
+func backupServersSequential(ctx context.Context, multiConfig *MultiServerConfig, guard *saveGuard) ([]*BackupResult, error) {
+	var failures []BackupFailure
+	var results []*BackupResult
 
-	for serverName, config := range multiConfig.Servers {
+	for _, serverName := range sortedServerNames(multiConfig) {
 		logger.Log("=" + strings.Repeat("=", 50))
-		if err := backupSingleServer(serverName, config); err != nil {
+		if result, err := backupSingleServer(ctx, serverName, multiConfig.Servers[serverName], guard); err != nil {
 			logger.Log("错误: %v", err)
-			failedServers = append(failedServers, serverName)
+			failures = append(failures, BackupFailure{ServerName: serverName, Err: err})
 		} else {
-			successCount++
+			results = append(results, result)
 		}
 		logger.Log("=" + strings.Repeat("=", 50))
 		logger.Log("")
 	}
 
 	// 显示备份结果摘要
-	logger.Log("备份结果摘要:")
-	logger.Log("  成功: %d 个服务器", successCount)
-	logger.Log("  失败: %d 个服务器", len(failedServers))
+	showBackupSummary(results, failures)
 
-	if len(failedServers) > 0 {
-		logger.Log("  失败的服务器: %s", strings.Join(failedServers, ", "))
-		return fmt.Errorf("部分服务器备份失败")
+	if len(failures) > 0 {
+		return results, fmt.Errorf("部分服务器备份失败")
 	}
 
-	return nil
+	return results, nil
 }
 
 // backupServersParallel 并行备份所有服务器
-func backupServersParallel(multiConfig *MultiServerConfig) error {
+func backupServersParallel(ctx context.Context, multiConfig *MultiServerConfig, guard *saveGuard) ([]*BackupResult, error) {
 	// 创建信号量控制并发数
 	semaphore := make(chan struct{}, multiConfig.MaxConcurrency)
 	var wg sync.WaitGroup
 	var mu sync.Mutex
-	var failedServers []string
-	successCount := 0
+	var failures []BackupFailure
+	var results []*BackupResult
 
 	logger.Log("启用并行备份，最大并发数: %d", multiConfig.MaxConcurrency)
 
@@ -790,14 +1066,14 @@ func backupServersParallel(multiConfig *MultiServerConfig) error {
 			defer func() { <-semaphore }()
 
 			logger.Log("[并行] 开始备份服务器: %s", name)
-			if err := backupSingleServer(name, cfg); err != nil {
+			if result, err := backupSingleServer(ctx, name, cfg, guard); err != nil {
 				mu.Lock()
-				failedServers = append(failedServers, name)
+				failures = append(failures, BackupFailure{ServerName: name, Err: err})
 				mu.Unlock()
 				logger.Log("[并行] 服务器 %s 备份失败: %v", name, err)
 			} else {
 				mu.Lock()
-				successCount++
+				results = append(results, result)
 				mu.Unlock()
 				logger.Log("[并行] 服务器 %s 备份成功", name)
 			}
@@ -808,91 +1084,41 @@ func backupServersParallel(multiConfig *MultiServerConfig) error {
 	wg.Wait()
 
 	// 显示备份结果摘要
-	logger.Log("并行备份结果摘要:")
-	logger.Log("  成功: %d 个服务器", successCount)
-	logger.Log("  失败: %d 个服务器", len(failedServers))
-
-	if len(failedServers) > 0 {
-		logger.Log("  失败的服务器: %s", strings.Join(failedServers, ", "))
-		return fmt.Errorf("部分服务器备份失败")
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].ServerName < results[j].ServerName
+	})
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].ServerName < failures[j].ServerName
+	})
+	showBackupSummary(results, failures)
+
+	if len(failures) > 0 {
+		return results, fmt.Errorf("部分服务器备份失败")
 	}
 
-	return nil
+	return results, nil
 }
 
-// cleanupSnapshots 清理旧快照
-func cleanupSnapshots(config *Config) {
-	logger.Log("开始清理旧快照...")
-	maxAttempts := 2
-
-	for attempt := 1; attempt <= maxAttempts; attempt++ {
-		logger.Log("尝试清理快照 (第 %d/%d 次)...", attempt, maxAttempts)
-
-		cmd := exec.Command("restic", "forget", "--prune",
-			"--keep-daily", strconv.Itoa(config.KeepDaily),
-			"--keep-weekly", strconv.Itoa(config.KeepWeekly),
-			"--keep-monthly", strconv.Itoa(config.KeepMonthly),
-			"--keep-last", strconv.Itoa(config.KeepLast),
-			"--tag", config.BackupTag)
-
-		output, err := cmd.CombinedOutput()
-
-		if err == nil {
-			logger.Log("快照清理完成")
-			return
-		}
-
-		outputStr := string(output)
-
-		// 检查是否是锁定问题
-		if strings.Contains(outputStr, "repository is already locked") {
-			logger.Log("清理时检测到仓库锁定，尝试解锁...")
-			unlockCmd := exec.Command("restic", "unlock")
-			if err := unlockCmd.Run(); err == nil {
-				logger.Log("解锁成功，重试清理...")
-				time.Sleep(2 * time.Second)
-			} else {
-				logger.Log("警告: 解锁失败")
-				break
-			}
-		} else {
-			logger.Log("清理失败，错误信息：")
-			lines := strings.Split(outputStr, "\n")
-			for i, line := range lines {
-				if i < 3 && line != "" {
-					logger.Log("  %s", line)
-				}
-			}
-			break
-		}
-	}
-
-	logger.Log("警告: 快照清理失败，但备份已完成")
+func main() {
+	os.Exit(runCLI(os.Args[1:]))
 }
 
-// cleanup 清理函数（错误处理）
-func cleanup(container string, saveOnExecuted bool) {
-	if saveOnExecuted {
-		return
-	}
-
-	logger.Log("脚本执行失败，尝试恢复 Minecraft 世界写入...")
-	if err := execDockerCommand(container, "rcon-cli", "save-on"); err != nil {
-		logger.Log("警告: 无法恢复世界写入")
+// runBackup 执行完整的备份流程（backup 子命令）
+func runBackup(ctx context.Context, opts *GlobalOptions, args []string) error {
+	fs := newFlagSet("backup", opts)
+	if _, err := parseInterspersed(fs, args); err != nil {
+		return err
 	}
-}
 
-func main() {
-	// 获取配置文件路径
-	configPath := getConfigPath()
+	configPath := opts.configPath()
 
 	// 检查系统依赖
-	if err := checkDependencies(); err != nil {
-		os.Exit(1)
+	if err := checkDependencies("restic"); err != nil {
+		return err
 	}
 
 	// 尝试加载配置文件
-	config, err := loadConfig(configPath)
+	config, err := loadConfig(ctx, configPath)
 	if err != nil {
 		if strings.Contains(err.Error(), "配置文件不存在") {
 			logger.Log("配置文件不存在: %s", configPath)
@@ -900,23 +1126,64 @@ func main() {
 
 			// 创建示例配置文件
 			if err := createSampleConfig(configPath); err != nil {
-				logger.Log("创建配置文件失败: %v", err)
-				os.Exit(1)
+				return fmt.Errorf("创建配置文件失败: %v", err)
 			}
-			os.Exit(0)
+			return nil
 		}
 
-		logger.Log("加载配置文件失败: %v", err)
-		os.Exit(1)
+		return fmt.Errorf("加载配置文件失败: %v", err)
+	}
+	if err := opts.selectServers(config); err != nil {
+		return err
 	}
 
-	// 检查网络连接
-	checkNetwork()
+	// 显示当前配置
+	if opts.DryRun {
+		showConfig(config)
+		if _, err := recoverSaveOff(ctx, config, true); err != nil {
+			logger.Log("[dry-run] 警告: %v", err)
+		}
+		for _, serverName := range sortedServerNames(config) {
+			serverConfig := config.Servers[serverName]
+			if err := resolveWorldDir(ctx, serverName, serverConfig); err != nil {
+				logger.Log("[dry-run] 警告: 服务器 %s: %v", serverName, err)
+			}
+			logger.Log("[dry-run] 将备份服务器 %s: %s -> 标签 %s", serverName, serverConfig.WorldDir, serverConfig.BackupTag)
+		}
+		for _, mirror := range config.Mirrors {
+			logger.Log("[dry-run] 将复制新快照到镜像 %s: %s", mirror.Name, mirror.Repository)
+		}
+		return nil
+	}
+
+	// 上一次备份仍在运行时按 run_lock 跳过、等待或失败
+	lock, err := acquireRunLock(ctx, config, "backup")
+	if errors.Is(err, errRunLockSkipped) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	// 持有运行锁之后再恢复上次异常退出时没有恢复写入的服务器，不会误恢复正在进行的备份
+	if _, err := recoverSaveOff(ctx, config, false); err != nil {
+		logger.Log("警告: %v", err)
+	}
+
+	// 检查服务器使用的容器运行时
+	if err := checkRuntimes(ctx, config); err != nil {
+		return err
+	}
+
+	// 检查仓库连通性
+	if err := checkNetwork(ctx, config); err != nil {
+		return err
+	}
 
 	// 验证 restic 仓库连接
-	logger.Log("验证 Restic 仓库连接...")
-	if err := checkRepositoryConnection(); err != nil {
-		os.Exit(1)
+	if err := checkRepositories(ctx, config); err != nil {
+		return err
 	}
 
 	// 显示当前配置
@@ -926,22 +1193,40 @@ func main() {
 	logger.Log("共 %d 个服务器需要备份", len(config.Servers))
 
 	// 备份所有启用的服务器
-	if err := backupAllServers(config); err != nil {
-		logger.Log("备份过程中发生错误: %v", err)
-		os.Exit(1)
+	journal, err := openSaveJournal()
+	if err != nil {
+		logger.Log("警告: %v，进程异常退出时不会自动恢复写入", err)
+	}
+	guard := newSaveGuard(journal)
+	results, err := backupAllServers(ctx, config, guard)
+	guard.restoreAll(ctx)
+
+	// 被信号中断时不再复制和清理快照
+	if ctx.Err() != nil {
+		logger.Log("备份已中断")
+		guard.report()
+		return fmt.Errorf("备份被中断")
+	}
+
+	// 将备份成功的服务器的新快照复制到镜像仓库
+	mirrorErr := copyToMirrors(ctx, config, results)
+
+	if err != nil {
+		return fmt.Errorf("备份过程中发生错误: %v", err)
 	}
 
 	// 显示最新快照信息
-	getLatestSnapshotInfo()
+	getLatestSnapshotInfo(ctx, config)
 
-	// 清理旧快照（使用共享的保留策略）
-	if len(config.Servers) > 0 {
-		// 获取任意一个服务器配置用于清理（因为保留策略是共享的）
-		for _, serverConfig := range config.Servers {
-			cleanupSnapshots(serverConfig)
-			break
-		}
+	// 按每个服务器的保留策略清理旧快照
+	if err := cleanupSnapshots(ctx, config, false); err != nil {
+		logger.Log("警告: %v，但备份已完成", err)
+	}
+
+	if mirrorErr != nil {
+		return mirrorErr
 	}
 
 	logger.Log("所有服务器备份流程全部完成")
+	return nil
 }
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// MirrorConfig 镜像仓库配置（[[mirrors]]）
+// 备份成功后，新快照会通过 restic copy 复制到每个镜像仓库
+type MirrorConfig struct {
+	// 镜像名称（用于日志和摘要）
+	Name string `toml:"name"`
+	// 仓库地址和密码（repository、password、password_file、password_command）
+	ResticConfig
+	// 镜像仓库的 AWS 凭证（未填写的字段沿用全局 [aws]，access_key_id 和 secret_access_key 作为整体沿用）
+	AWS AWSConfig `toml:"aws"`
+	// 镜像仓库的保留策略（未设置时使用全局 [retention]）
+	Retention RetentionConfig `toml:"retention"`
+}
+
+// Mirror 镜像仓库运行时配置
+type Mirror struct {
+	Name       string
+	Repository *Repository
+	Retention  RetentionConfig
+}
+
+// MirrorResult 单个服务器的快照复制到单个镜像仓库的结果
+type MirrorResult struct {
+	Mirror     string
+	ServerName string
+	SnapshotID string
+	Err        error
+}
+
+// newMirror 根据 [[mirrors]] 配置创建镜像仓库（index 为配置中的序号，用于默认名称和错误信息）
+// restic 为全局 [restic] 配置，只使用其中的 lock_wait 和 auto_init
+func newMirror(index int, mirrorConfig MirrorConfig, restic ResticConfig, aws AWSConfig, retention RetentionConfig) (*Mirror, error) {
+	name := mirrorConfig.Name
+	if name == "" {
+		name = fmt.Sprintf("mirror-%d", index+1)
+	}
+	if mirrorConfig.Repository == "" {
+		return nil, fmt.Errorf("镜像 %s 未设置 repository", name)
+	}
+
+	// 仓库地址和密码不沿用全局配置，避免复制回主仓库
+	repo := newRepository(ResticConfig{LockWait: restic.LockWait, AutoInit: restic.AutoInit}, mirrorConfig.ResticConfig, aws, mirrorConfig.AWS)
+	if !repo.hasPassword() {
+		return nil, fmt.Errorf("镜像 %s 未设置 password、password_file 或 password_command", name)
+	}
+
+	if !mirrorConfig.Retention.isEmpty() {
+		retention = mirrorConfig.Retention
+	}
+
+	return &Mirror{Name: name, Repository: repo, Retention: retention}, nil
+}
+
+// copyToMirrors 将本次备份创建的快照复制到所有镜像仓库
+// 只处理本次备份成功的服务器，返回的错误表示至少一次复制失败
+func copyToMirrors(ctx context.Context, multiConfig *MultiServerConfig, backups []*BackupResult) error {
+	if len(multiConfig.Mirrors) == 0 || len(backups) == 0 {
+		return nil
+	}
+
+	logger.Log("开始复制快照到镜像仓库...")
+
+	var results []MirrorResult
+	failed := 0
+	for _, mirror := range multiConfig.Mirrors {
+		for _, backup := range backups {
+			result := copySnapshotToMirror(ctx, backup, multiConfig.Servers[backup.ServerName], mirror)
+			if result.Err != nil {
+				logger.Log("警告: 服务器 %s 的快照复制到镜像 %s 失败: %v", backup.ServerName, mirror.Name, result.Err)
+				failed++
+			}
+			results = append(results, result)
+		}
+	}
+
+	showMirrorSummary(multiConfig.Mirrors, results)
+
+	if failed > 0 {
+		return fmt.Errorf("%d 个快照复制到镜像仓库失败", failed)
+	}
+	return nil
+}
+
+// copySnapshotToMirror 将本次备份创建的快照复制到镜像仓库
+func copySnapshotToMirror(ctx context.Context, backup *BackupResult, config *Config, mirror *Mirror) MirrorResult {
+	result := MirrorResult{Mirror: mirror.Name, ServerName: backup.ServerName, SnapshotID: backup.ShortID()}
+
+	// 本地镜像先检查剩余空间（按本次备份的处理量估算）
+	if mirror.Repository.isLocal() {
+		if err := checkFreeSpace(mirror.Repository, backup.TotalBytes); err != nil {
+			result.Err = err
+			return result
+		}
+	}
+
+	logger.Log("[%s] 复制快照 %s 到镜像 %s (%s)...", backup.ServerName, backup.ShortID(), mirror.Name, mirror.Repository)
+	if _, err := runResticWithLockWait(ctx, copyTarget(config.Repository, mirror.Repository), copyEnv(config.Repository), "copy", backup.SnapshotID); err != nil {
+		result.Err = err
+	}
+	return result
+}
+
+// showMirrorSummary 显示每个镜像仓库的复制结果
+func showMirrorSummary(mirrors []*Mirror, results []MirrorResult) {
+	logger.Log("镜像复制摘要:")
+	for _, mirror := range mirrors {
+		var succeeded []string
+		var failed []string
+		for _, result := range results {
+			if result.Mirror != mirror.Name {
+				continue
+			}
+			if result.Err != nil {
+				failed = append(failed, fmt.Sprintf("%s (%v)", result.ServerName, result.Err))
+			} else {
+				succeeded = append(succeeded, fmt.Sprintf("%s@%s", result.ServerName, result.SnapshotID))
+			}
+		}
+
+		status := "成功"
+		if len(failed) > 0 {
+			status = "失败"
+		}
+		logger.Log("  %s: %s（成功 %d 个，失败 %d 个）", mirror.Name, status, len(succeeded), len(failed))
+		if len(succeeded) > 0 {
+			logger.Log("    已复制: %s", strings.Join(succeeded, ", "))
+		}
+		for _, item := range failed {
+			logger.Log("    失败: %s", item)
+		}
+	}
+}
+
+// copyEnv 返回 restic copy 读取源仓库所需的环境变量
+func copyEnv(source *Repository) []string {
+	env := []string{"RESTIC_FROM_REPOSITORY=" + source.URL}
+	switch {
+	case source.PasswordCommand != "":
+		env = append(env, "RESTIC_FROM_PASSWORD_COMMAND="+source.PasswordCommand)
+	case source.PasswordFile != "":
+		env = append(env, "RESTIC_FROM_PASSWORD_FILE="+source.PasswordFile)
+	default:
+		env = append(env, "RESTIC_FROM_PASSWORD="+source.Password)
+	}
+	return env
+}
+
+// copyTarget 返回执行 restic copy 时使用的目标仓库
+// restic copy 的源仓库和目标仓库共用同一组 AWS 环境变量：目标不是 S3 仓库时用不到凭证，
+// 改用源仓库的凭证（例如服务器在 [servers.X.aws] 中单独设置的存储桶凭证）
+func copyTarget(source, mirror *Repository) *Repository {
+	if mirror.backend() == backendS3 {
+		return mirror
+	}
+	target := *mirror
+	target.AWSAccessKeyID = source.AWSAccessKeyID
+	target.AWSSecretAccessKey = source.AWSSecretAccessKey
+	target.AWSRegion = source.AWSRegion
+	return &target
+}
+
+// validateMirrors 检查镜像仓库配置
+func validateMirrors(multiConfig *MultiServerConfig) []string {
+	var problems []string
+	names := make(map[string]bool)
+	for _, mirror := range multiConfig.Mirrors {
+		if names[mirror.Name] {
+			problems = append(problems, fmt.Sprintf("[[mirrors]] 名称重复: %s", mirror.Name))
+		}
+		names[mirror.Name] = true
+
+		if err := mirror.Retention.validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("[[mirrors]] %s 保留策略无效: %v", mirror.Name, err))
+		}
+
+		if mirror.Repository.hasPartialAWSCredentials() {
+			problems = append(problems, fmt.Sprintf("[[mirrors]] %s AWS 凭证不完整: access_key_id 和 secret_access_key 需要同时填写", mirror.Name))
+		}
+
+		for _, repo := range repositoriesOf(multiConfig) {
+			if repo.URL == mirror.Repository.URL {
+				problems = append(problems, fmt.Sprintf("[[mirrors]] %s 与服务器使用的仓库相同: %s", mirror.Name, repo))
+				continue
+			}
+			// restic copy 的源仓库和目标仓库共用同一组 AWS 环境变量，S3 源仓库必须能使用复制进程的凭证
+			target := copyTarget(repo, mirror.Repository)
+			if repo.backend() == backendS3 &&
+				(repo.AWSAccessKeyID != target.AWSAccessKeyID || repo.AWSSecretAccessKey != target.AWSSecretAccessKey) {
+				problems = append(problems, fmt.Sprintf("[[mirrors]] %s 与 S3 仓库 %s 的 AWS 凭证不同，restic copy 无法同时使用两组凭证", mirror.Name, repo))
+			}
+		}
+	}
+	return problems
+}
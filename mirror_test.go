@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCopySnapshotPerServerCredentialsToLocalMirror(t *testing.T) {
+	// 记录环境变量的 restic
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "env")
+	script := "#!/bin/sh\nenv > " + envFile + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "restic"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "ambient-id")
+
+	global := AWSConfig{AccessKeyID: "global-id", SecretAccessKey: "global-secret"}
+	source := newRepository(ResticConfig{Repository: "s3:https://r2.example.com/minecraft", Password: "secret"},
+		ResticConfig{Repository: "s3:https://r2.example.com/staff"},
+		global, AWSConfig{AccessKeyID: "staff-id", SecretAccessKey: "staff-secret"})
+	mirror, err := newMirror(0, MirrorConfig{Name: "local", ResticConfig: ResticConfig{Repository: filepath.Join(dir, "mirror"), Password: "mirror"}},
+		ResticConfig{}, global, RetentionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	multi := &MultiServerConfig{
+		Repository: source,
+		Servers:    map[string]*Config{"staff": {Repository: source}},
+		Mirrors:    []*Mirror{mirror},
+	}
+	if problems := validateMirrors(multi); len(problems) != 0 {
+		t.Errorf("validateMirrors() = %q, want no problems", problems)
+	}
+
+	backup := &BackupResult{ServerName: "staff", SnapshotID: "0123456789abcdef"}
+	if result := copySnapshotToMirror(context.Background(), backup, multi.Servers["staff"], mirror); result.Err != nil {
+		t.Fatalf("copySnapshotToMirror() error = %v", result.Err)
+	}
+
+	data, err := os.ReadFile(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		if key, value, ok := strings.Cut(line, "="); ok {
+			env[key] = value
+		}
+	}
+	// 源仓库是服务器单独的存储桶，复制进程必须使用服务器的凭证
+	if env["AWS_ACCESS_KEY_ID"] != "staff-id" || env["AWS_SECRET_ACCESS_KEY"] != "staff-secret" {
+		t.Errorf("AWS credentials = %q/%q, want the server's", env["AWS_ACCESS_KEY_ID"], env["AWS_SECRET_ACCESS_KEY"])
+	}
+	if env["RESTIC_FROM_REPOSITORY"] != source.URL || env["RESTIC_REPOSITORY"] != mirror.Repository.URL {
+		t.Errorf("repositories = %q -> %q", env["RESTIC_FROM_REPOSITORY"], env["RESTIC_REPOSITORY"])
+	}
+}
+
+func TestValidateMirrorsS3Credentials(t *testing.T) {
+	global := AWSConfig{AccessKeyID: "global-id", SecretAccessKey: "global-secret"}
+	staff := newRepository(ResticConfig{Repository: "s3:https://r2.example.com/minecraft", Password: "secret"},
+		ResticConfig{Repository: "s3:https://r2.example.com/staff"},
+		global, AWSConfig{AccessKeyID: "staff-id", SecretAccessKey: "staff-secret"})
+
+	tests := []struct {
+		name     string
+		mirror   MirrorConfig
+		problems int
+	}{
+		{"local mirror", MirrorConfig{ResticConfig: ResticConfig{Repository: "/srv/mirror", Password: "x"}}, 0},
+		{"sftp mirror", MirrorConfig{ResticConfig: ResticConfig{Repository: "sftp:backup@nas:/restic", Password: "x"}}, 0},
+		{"s3 mirror with global credentials", MirrorConfig{ResticConfig: ResticConfig{Repository: "s3:https://s3.example.com/mirror", Password: "x"}}, 1},
+		{"s3 mirror with the same credentials", MirrorConfig{
+			ResticConfig: ResticConfig{Repository: "s3:https://s3.example.com/mirror", Password: "x"},
+			AWS:          AWSConfig{AccessKeyID: "staff-id", SecretAccessKey: "staff-secret"},
+		}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mirror, err := newMirror(0, tt.mirror, ResticConfig{}, global, RetentionConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			multi := &MultiServerConfig{
+				Repository: staff,
+				Servers:    map[string]*Config{"staff": {Repository: staff}},
+				Mirrors:    []*Mirror{mirror},
+			}
+			if problems := validateMirrors(multi); len(problems) != tt.problems {
+				t.Errorf("validateMirrors() = %q, want %d problems", problems, tt.problems)
+			}
+		})
+	}
+}
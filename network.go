@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// probeTimeout 单个仓库连通性检查的超时时间
+const probeTimeout = 10 * time.Second
+
+// access(2) 的权限位
+const (
+	accessExecute = 0x1
+	accessWrite   = 0x2
+	accessRead    = 0x4
+)
+
+// Endpoint 远程仓库的网络端点
+type Endpoint struct {
+	Host string
+	Port string
+	// 是否需要 TLS 握手（https 端点）
+	TLS bool
+}
+
+// String 返回 host:port
+func (e Endpoint) String() string {
+	return net.JoinHostPort(e.Host, e.Port)
+}
+
+// ProbeResult 单个仓库的连通性检查结果
+type ProbeResult struct {
+	Repository *Repository
+	// 实际连接的端点（经过代理时为代理地址）
+	Endpoint Endpoint
+	Proxy    bool
+	DNS      time.Duration
+	Connect  time.Duration
+	TLS      time.Duration
+	// 无法确定端点，未执行检查的原因
+	Skipped string
+	Err     error
+}
+
+// String 返回检查结果的简短描述
+func (p ProbeResult) String() string {
+	if p.Skipped != "" {
+		return "跳过: " + p.Skipped
+	}
+	if p.Repository.isLocal() {
+		return "本地目录可访问"
+	}
+
+	target := p.Endpoint.String()
+	if p.Proxy {
+		target = "代理 " + target
+	}
+	parts := []string{fmt.Sprintf("DNS %s", formatLatency(p.DNS)), fmt.Sprintf("TCP %s", formatLatency(p.Connect))}
+	if p.Endpoint.TLS && !p.Proxy {
+		parts = append(parts, fmt.Sprintf("TLS %s", formatLatency(p.TLS)))
+	}
+	return fmt.Sprintf("%s 可达（%s）", target, strings.Join(parts, "，"))
+}
+
+// formatLatency 将耗时格式化为毫秒
+func formatLatency(d time.Duration) string {
+	return fmt.Sprintf("%d ms", d.Milliseconds())
+}
+
+// repositoryEndpoint 从仓库地址解析需要连接的网络端点
+// 返回空 Host 表示无法从地址确定端点（例如 rclone 或由环境变量决定的后端）
+func repositoryEndpoint(repo *Repository) (Endpoint, error) {
+	_, location, _ := strings.Cut(repo.URL, ":")
+
+	switch repo.backend() {
+	case backendS3:
+		// s3:https://host/bucket、s3:http://host/bucket 或 s3:host/bucket（默认 https）
+		if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+			location = "https://" + location
+		}
+		return httpEndpoint(location)
+	case backendREST:
+		return httpEndpoint(location)
+	case backendSFTP:
+		return sftpEndpoint(location)
+	case backendB2:
+		return Endpoint{Host: "api.backblazeb2.com", Port: "443", TLS: true}, nil
+	case backendGS:
+		return Endpoint{Host: "storage.googleapis.com", Port: "443", TLS: true}, nil
+	case backendAzure:
+		account := os.Getenv("AZURE_ACCOUNT_NAME")
+		if account == "" {
+			return Endpoint{}, nil
+		}
+		return Endpoint{Host: account + ".blob.core.windows.net", Port: "443", TLS: true}, nil
+	case backendSwift:
+		if authURL := os.Getenv("OS_AUTH_URL"); authURL != "" {
+			return httpEndpoint(authURL)
+		}
+		return Endpoint{}, nil
+	default:
+		return Endpoint{}, nil
+	}
+}
+
+// httpEndpoint 解析 http(s) 地址的端点
+func httpEndpoint(rawURL string) (Endpoint, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Endpoint{}, fmt.Errorf("无法解析仓库地址: %v", err)
+	}
+	if u.Hostname() == "" {
+		return Endpoint{}, fmt.Errorf("仓库地址中没有主机名: %s", rawURL)
+	}
+
+	endpoint := Endpoint{Host: u.Hostname(), Port: u.Port(), TLS: u.Scheme == "https"}
+	if endpoint.Port == "" {
+		endpoint.Port = "80"
+		if endpoint.TLS {
+			endpoint.Port = "443"
+		}
+	}
+	return endpoint, nil
+}
+
+// sftpEndpoint 解析 sftp 仓库的端点
+// 支持 sftp:user@host:/path 和 sftp://user@host:port/path 两种格式
+func sftpEndpoint(location string) (Endpoint, error) {
+	if strings.HasPrefix(location, "//") {
+		u, err := url.Parse("sftp:" + location)
+		if err != nil {
+			return Endpoint{}, fmt.Errorf("无法解析仓库地址: %v", err)
+		}
+		endpoint := Endpoint{Host: u.Hostname(), Port: u.Port()}
+		if endpoint.Port == "" {
+			endpoint.Port = "22"
+		}
+		return endpoint, nil
+	}
+
+	host, _, ok := strings.Cut(location, ":")
+	if !ok || host == "" {
+		return Endpoint{}, fmt.Errorf("无法解析 sftp 仓库地址: %s", location)
+	}
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	return Endpoint{Host: host, Port: "22"}, nil
+}
+
+// proxyFor 返回访问端点时使用的 HTTP 代理（未使用代理时为 nil）
+func proxyFor(endpoint Endpoint) *url.URL {
+	scheme := "http"
+	if endpoint.TLS {
+		scheme = "https"
+	}
+	req := &http.Request{URL: &url.URL{Scheme: scheme, Host: endpoint.String()}}
+	proxy, err := http.ProxyFromEnvironment(req)
+	if err != nil {
+		return nil
+	}
+	return proxy
+}
+
+// probeRepository 检查仓库是否可以访问
+// 远程仓库依次执行 DNS 解析、TCP 连接和 TLS 握手并记录耗时；本地仓库检查目录的读写权限
+func probeRepository(ctx context.Context, repo *Repository) ProbeResult {
+	result := ProbeResult{Repository: repo}
+	if repo.isLocal() {
+		result.Err = checkLocalRepositoryPath(repo.localPath())
+		return result
+	}
+
+	endpoint, err := repositoryEndpoint(repo)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if endpoint.Host == "" {
+		result.Skipped = fmt.Sprintf("无法从 %s 仓库地址确定网络端点", repo.backend())
+		return result
+	}
+
+	// restic 对 http(s) 后端会使用环境变量中的代理，此时只检查代理是否可达
+	if repo.backend() != backendSFTP {
+		if proxy := proxyFor(endpoint); proxy != nil {
+			proxyEndpoint, err := httpEndpoint(proxy.String())
+			if err != nil {
+				result.Err = fmt.Errorf("代理地址无效: %v", err)
+				return result
+			}
+			endpoint = proxyEndpoint
+			result.Proxy = true
+		}
+	}
+	result.Endpoint = endpoint
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, endpoint.Host)
+	result.DNS = time.Since(start)
+	if err != nil {
+		if repo.backend() == backendSFTP {
+			// sftp 的主机名可能是 ~/.ssh/config 中的别名，由 ssh 自己解析
+			result.Skipped = fmt.Sprintf("无法解析 %s（可能是 ssh 配置中的别名）", endpoint.Host)
+			return result
+		}
+		result.Err = fmt.Errorf("DNS 解析 %s 失败: %v", endpoint.Host, err)
+		return result
+	}
+
+	start = time.Now()
+	conn, err := dialAddresses(ctx, addrs, endpoint.Port)
+	result.Connect = time.Since(start)
+	if err != nil {
+		result.Err = fmt.Errorf("无法连接 %s: %v", endpoint, err)
+		return result
+	}
+	defer conn.Close()
+
+	if !endpoint.TLS || result.Proxy {
+		return result
+	}
+
+	start = time.Now()
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: endpoint.Host})
+	err = tlsConn.HandshakeContext(ctx)
+	result.TLS = time.Since(start)
+	if err != nil {
+		result.Err = fmt.Errorf("与 %s 的 TLS 握手失败: %v", endpoint, err)
+	}
+	return result
+}
+
+// dialAddresses 依次尝试解析到的每个地址，返回第一个成功的连接
+// 主机没有可用的 IPv6 时第一个 AAAA 地址会失败，不能因此判定仓库不可达
+func dialAddresses(ctx context.Context, addrs []string, port string) (net.Conn, error) {
+	var dialer net.Dialer
+	var firstErr error
+	for i, addr := range addrs {
+		// 剩余时间平均分给剩下的地址，避免一个无响应的地址用完全部时间
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok {
+			attemptCtx, cancel = context.WithTimeout(ctx, time.Until(deadline)/time.Duration(len(addrs)-i))
+		}
+		conn, err := dialer.DialContext(attemptCtx, "tcp", net.JoinHostPort(addr, port))
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+		logger.Debug("连接 %s 失败: %v", net.JoinHostPort(addr, port), err)
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, firstErr
+}
+
+// checkLocalRepositoryPath 检查本地仓库目录是否可以读写
+// 目录不存在时检查其上级目录能否创建仓库（restic init）
+func checkLocalRepositoryPath(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		parent := filepath.Dir(filepath.Clean(path))
+		if _, err := os.Stat(parent); err != nil {
+			return fmt.Errorf("仓库目录 %s 及其上级目录都不存在", path)
+		}
+		if err := syscall.Access(parent, accessWrite|accessExecute); err != nil {
+			return fmt.Errorf("仓库目录 %s 不存在，且没有权限在 %s 中创建: %v", path, parent, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("无法访问仓库目录 %s: %v", path, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("仓库路径 %s 不是目录", path)
+	}
+	if err := syscall.Access(path, accessRead|accessWrite|accessExecute); err != nil {
+		return fmt.Errorf("没有仓库目录 %s 的读写权限: %v", path, err)
+	}
+	return nil
+}
+
+// checkNetwork 检查所有仓库是否可以访问
+// 服务器使用的仓库不可访问时返回错误，镜像仓库不可访问时只记录警告
+func checkNetwork(ctx context.Context, multiConfig *MultiServerConfig) error {
+	logger.Log("检查仓库连通性...")
+
+	var unreachable []string
+	for _, repo := range repositoriesOf(multiConfig) {
+		result := probeRepository(ctx, repo)
+		if result.Err != nil {
+			logger.Log("  %s: 不可访问: %v", repo, result.Err)
+			unreachable = append(unreachable, repo.String())
+			continue
+		}
+		logger.Log("  %s: %s", repo, result)
+	}
+	for _, mirror := range multiConfig.Mirrors {
+		result := probeRepository(ctx, mirror.Repository)
+		if result.Err != nil {
+			logger.Log("  警告: 镜像 %s (%s) 不可访问: %v", mirror.Name, mirror.Repository, result.Err)
+			continue
+		}
+		logger.Log("  镜像 %s (%s): %s", mirror.Name, mirror.Repository, result)
+	}
+
+	if len(unreachable) > 0 {
+		return fmt.Errorf("%d 个仓库不可访问: %s", len(unreachable), strings.Join(unreachable, ", "))
+	}
+	return nil
+}
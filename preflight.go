@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// dirSize 统计目录中所有普通文件的总大小
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// 服务器运行中文件可能被删除，跳过即可
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// freeSpace 返回路径所在文件系统对当前用户可用的空间
+// 路径不存在时使用最近的已存在的上级目录（仓库目录可能尚未创建）
+func freeSpace(path string) (uint64, error) {
+	path = filepath.Clean(path)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+// formatBytes 将字节数格式化为易读的大小
+func formatBytes(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := uint64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// checkFreeSpace 检查本地仓库所在文件系统能否容纳 needed 字节的数据
+// 非本地仓库无法在本机检查，直接返回 nil
+func checkFreeSpace(repo *Repository, needed uint64) error {
+	if !repo.isLocal() {
+		return nil
+	}
+
+	free, err := freeSpace(repo.localPath())
+	if err != nil {
+		return fmt.Errorf("无法获取仓库 %s 的剩余空间: %v", repo, err)
+	}
+	logger.Debug("仓库 %s 剩余空间 %s，需要 %s", repo, formatBytes(free), formatBytes(needed))
+	if needed > free {
+		return fmt.Errorf("仓库 %s 所在文件系统剩余空间不足: 需要约 %s（世界目录大小），可用 %s",
+			repo, formatBytes(needed), formatBytes(free))
+	}
+	return nil
+}
+
+// checkWorldFitsRepository 备份前检查本地仓库是否有足够空间容纳世界目录
+// 按世界目录的完整大小估算，增量备份实际写入的数据通常远小于此值
+func checkWorldFitsRepository(serverName string, config *Config) error {
+	if !config.Repository.isLocal() {
+		return nil
+	}
+
+	size, err := dirSize(config.WorldDir)
+	if err != nil {
+		return fmt.Errorf("无法统计世界目录大小: %v", err)
+	}
+	logger.Log("[%s] 世界目录大小: %s", serverName, formatBytes(uint64(size)))
+	return checkFreeSpace(config.Repository, uint64(size))
+}
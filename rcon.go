@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Source RCON 协议数据包类型
+const (
+	rconTypeResponseValue int32 = 0
+	rconTypeExecCommand   int32 = 2
+	rconTypeAuthResponse  int32 = 2
+	rconTypeAuth          int32 = 3
+)
+
+const (
+	// 默认 RCON 端口
+	defaultRconPort = 25575
+	// 默认 RCON 超时时间
+	defaultRconTimeout = 10 * time.Second
+	// 单个响应数据包的最大正文长度（字符数），更长的响应会被服务端拆成多个数据包
+	// 服务端按 Java 字符（UTF-16）拆分后再编码为 UTF-8，所以数据包的字节数可能远大于 4096
+	rconMaxResponseBody = 4096
+	// 单个数据包的最大长度：每个字符编码后最多 4 字节
+	rconMaxPacketSize = rconMaxResponseBody*4 + 14
+)
+
+// errRconAuthFailed RCON 密码错误
+var errRconAuthFailed = errors.New("RCON 认证失败，请检查 rcon_password")
+
+// rconPacket RCON 数据包
+type rconPacket struct {
+	ID   int32
+	Type int32
+	Body string
+}
+
+// RconClient Source RCON 协议客户端
+type RconClient struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+	nextID  int32
+}
+
+// dialRcon 连接 RCON 服务并完成认证
+func dialRcon(ctx context.Context, address, password string, timeout time.Duration) (*RconClient, error) {
+	if timeout <= 0 {
+		timeout = defaultRconTimeout
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("无法连接 RCON %s: %w", address, err)
+	}
+
+	client := &RconClient{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: timeout,
+		nextID:  1,
+	}
+
+	if err := client.auth(password); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// Close 关闭连接
+func (c *RconClient) Close() error {
+	return c.conn.Close()
+}
+
+// auth 发送认证请求
+func (c *RconClient) auth(password string) error {
+	id := c.allocID()
+	if err := c.writePacket(rconPacket{ID: id, Type: rconTypeAuth, Body: password}); err != nil {
+		return err
+	}
+
+	for {
+		packet, err := c.readPacket()
+		if err != nil {
+			return fmt.Errorf("读取 RCON 认证响应失败: %v", err)
+		}
+
+		// Source 服务端会先返回一个空的 RESPONSE_VALUE，跳过即可
+		if packet.Type != rconTypeAuthResponse {
+			continue
+		}
+		if packet.ID == -1 {
+			return errRconAuthFailed
+		}
+		if packet.ID != id {
+			return fmt.Errorf("RCON 认证响应 ID 不匹配: %d", packet.ID)
+		}
+		return nil
+	}
+}
+
+// Command 执行命令并返回完整的响应文本
+func (c *RconClient) Command(command string) (string, error) {
+	id := c.allocID()
+	if err := c.writePacket(rconPacket{ID: id, Type: rconTypeExecCommand, Body: command}); err != nil {
+		return "", err
+	}
+
+	var response strings.Builder
+	for {
+		packet, err := c.readResponse()
+		if err != nil {
+			return "", err
+		}
+		if packet.ID == id {
+			response.WriteString(packet.Body)
+			if rconBodyLength(packet.Body) < rconMaxResponseBody {
+				return response.String(), nil
+			}
+			break
+		}
+	}
+
+	// 正文达到单个数据包的上限，说明响应被拆成了多个数据包：发送一个空包作为结束标记，
+	// 服务端按顺序处理请求，收到结束标记的响应即说明命令的响应已全部返回
+	// 原版服务端每次只读取一个数据包，与命令一起发送会导致服务端断开连接，所以只在这里发送
+	sentinelID := c.allocID()
+	if err := c.writePacket(rconPacket{ID: sentinelID, Type: rconTypeResponseValue}); err != nil {
+		return "", err
+	}
+	for {
+		packet, err := c.readResponse()
+		if err != nil {
+			return "", err
+		}
+		switch packet.ID {
+		case id:
+			response.WriteString(packet.Body)
+		case sentinelID:
+			return response.String(), nil
+		}
+	}
+}
+
+// rconBodyLength 返回正文的 Java 字符数（UTF-16 编码单元），与服务端拆分响应时的计数方式一致
+func rconBodyLength(body string) int {
+	length := 0
+	for _, r := range body {
+		if r >= 0x10000 {
+			length += 2
+		} else {
+			length++
+		}
+	}
+	return length
+}
+
+// readResponse 读取一个命令响应数据包
+func (c *RconClient) readResponse() (rconPacket, error) {
+	packet, err := c.readPacket()
+	if err != nil {
+		return packet, fmt.Errorf("读取 RCON 响应失败: %w", err)
+	}
+	if packet.ID == -1 {
+		return packet, errRconAuthFailed
+	}
+	return packet, nil
+}
+
+// allocID 分配请求 ID
+func (c *RconClient) allocID() int32 {
+	id := c.nextID
+	c.nextID++
+	if c.nextID <= 0 {
+		c.nextID = 1
+	}
+	return id
+}
+
+// writePacket 发送数据包
+func (c *RconClient) writePacket(packet rconPacket) error {
+	// 长度 = ID(4) + 类型(4) + 正文 + 两个结尾的空字节
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, int32(4+4+len(packet.Body)+2))
+	binary.Write(&buf, binary.LittleEndian, packet.ID)
+	binary.Write(&buf, binary.LittleEndian, packet.Type)
+	buf.WriteString(packet.Body)
+	buf.Write([]byte{0, 0})
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("发送 RCON 数据包失败: %w", err)
+	}
+	return nil
+}
+
+// readPacket 读取一个数据包
+func (c *RconClient) readPacket() (rconPacket, error) {
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+
+	var length int32
+	if err := binary.Read(c.reader, binary.LittleEndian, &length); err != nil {
+		return rconPacket{}, err
+	}
+	if length < 10 || length > rconMaxPacketSize {
+		return rconPacket{}, fmt.Errorf("无效的 RCON 数据包长度: %d", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, data); err != nil {
+		return rconPacket{}, err
+	}
+
+	packet := rconPacket{
+		ID:   int32(binary.LittleEndian.Uint32(data[0:4])),
+		Type: int32(binary.LittleEndian.Uint32(data[4:8])),
+		Body: string(bytes.TrimRight(data[8:], "\x00")),
+	}
+	return packet, nil
+}
+
+// isTimeoutError 判断是否为超时错误
+func isTimeoutError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// rconAddress 返回服务器的 RCON 地址
+func rconAddress(config *Config) string {
+	host := config.RconHost
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	port := config.RconPort
+	if port == 0 {
+		port = defaultRconPort
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// useNativeRcon 判断服务器是否配置了原生 RCON
+func useNativeRcon(config *Config) bool {
+	return config.RconPassword != ""
+}
+
+// sendServerCommand 向 Minecraft 服务器发送控制台命令并返回响应文本
+// 配置了 rcon_password 时使用原生 RCON，否则交给服务器控制器发送
+// （容器内执行 rcon-cli，或向 tmux/screen 会话注入按键）
+func sendServerCommand(ctx context.Context, config *Config, command string, timeout time.Duration) (string, error) {
+	if !useNativeRcon(config) {
+		controller, err := controllerFor(config)
+		if err != nil {
+			return "", err
+		}
+		return controller.SendCommand(ctx, command, timeout)
+	}
+
+	address := rconAddress(config)
+	logger.Debug("RCON %s: %s", address, command)
+
+	// 整个命令（连接、认证和读取响应）不超过 timeout，服务端持续发送数据时也不会无限等待
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	client, err := dialRcon(ctx, address, config.RconPassword, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	// 取消时关闭连接，中断正在等待的读写
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+
+	response, err := client.Command(command)
+	if ctx.Err() != nil {
+		return response, ctx.Err()
+	}
+	return response, err
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// vanillaRconServer 模拟原版服务端的 RCON 实现：
+// 每次只读取一次（最多 1460 字节），长度字段与读取到的字节数不一致时直接断开连接，
+// 响应按 4096 个字符拆分后再编码为 UTF-8，未知类型的请求返回 "Unknown request"
+func vanillaRconServer(t *testing.T, password string, handler func(command string) string) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	send := func(conn net.Conn, id, typ int32, body string) {
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.LittleEndian, int32(len(body)+10))
+		binary.Write(&buf, binary.LittleEndian, id)
+		binary.Write(&buf, binary.LittleEndian, typ)
+		buf.WriteString(body)
+		buf.Write([]byte{0, 0})
+		conn.Write(buf.Bytes())
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 1460)
+				for {
+					n, err := conn.Read(buf)
+					if err != nil || n < 10 {
+						return
+					}
+					if int(binary.LittleEndian.Uint32(buf[0:4])) != n-4 {
+						return
+					}
+					id := int32(binary.LittleEndian.Uint32(buf[4:8]))
+					typ := int32(binary.LittleEndian.Uint32(buf[8:12]))
+					body := string(bytes.TrimRight(buf[12:n], "\x00"))
+
+					switch typ {
+					case rconTypeAuth:
+						if body == password {
+							send(conn, id, rconTypeAuthResponse, "")
+						} else {
+							send(conn, -1, rconTypeAuthResponse, "")
+						}
+					case rconTypeExecCommand:
+						response := []rune(handler(body))
+						for {
+							chunk := min(len(response), rconMaxResponseBody)
+							send(conn, id, rconTypeResponseValue, string(response[:chunk]))
+							response = response[chunk:]
+							if len(response) == 0 {
+								break
+							}
+						}
+					default:
+						send(conn, id, rconTypeResponseValue, fmt.Sprintf("Unknown request %x", typ))
+					}
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestRconCommand(t *testing.T) {
+	address := vanillaRconServer(t, "secret", func(command string) string {
+		return "reply to " + command
+	})
+
+	client, err := dialRcon(context.Background(), address, "secret", 2*time.Second)
+	if err != nil {
+		t.Fatalf("dialRcon: %v", err)
+	}
+	defer client.Close()
+
+	for _, command := range []string{"save-off", "save-all flush", "save-on"} {
+		response, err := client.Command(command)
+		if err != nil {
+			t.Fatalf("Command(%q): %v", command, err)
+		}
+		if want := "reply to " + command; response != want {
+			t.Errorf("Command(%q) = %q, want %q", command, response, want)
+		}
+	}
+}
+
+func TestRconCommandSplitResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+	}{
+		{"exactly one packet", strings.Repeat("x", rconMaxResponseBody)},
+		{"two packets", strings.Repeat("x", rconMaxResponseBody+100)},
+		{"two full packets", strings.Repeat("x", 2*rconMaxResponseBody)},
+		// 中文玩家名等多字节字符：一个数据包的正文远超过 4096 字节
+		{"multibyte", "There are 2 of a max of 20 players online: " + strings.Repeat("玩家", rconMaxResponseBody)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address := vanillaRconServer(t, "secret", func(command string) string {
+				return tt.response
+			})
+
+			client, err := dialRcon(context.Background(), address, "secret", 2*time.Second)
+			if err != nil {
+				t.Fatalf("dialRcon: %v", err)
+			}
+			defer client.Close()
+
+			response, err := client.Command("list")
+			if err != nil {
+				t.Fatalf("Command: %v", err)
+			}
+			if response != tt.response {
+				t.Errorf("response length = %d, want %d", len(response), len(tt.response))
+			}
+
+			// 结束标记的响应已被读取，后续命令不受影响
+			response, err = client.Command("list")
+			if err != nil || response != tt.response {
+				t.Errorf("second Command: length %d, err %v", len(response), err)
+			}
+		})
+	}
+}
+
+func TestRconAuthFailed(t *testing.T) {
+	address := vanillaRconServer(t, "secret", func(command string) string { return "" })
+
+	_, err := dialRcon(context.Background(), address, "wrong", 2*time.Second)
+	if !errors.Is(err, errRconAuthFailed) {
+		t.Fatalf("dialRcon with wrong password: err = %v, want errRconAuthFailed", err)
+	}
+}
+
+func TestRconPacketCodec(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	c := &RconClient{conn: client, timeout: time.Second}
+	go c.writePacket(rconPacket{ID: 7, Type: rconTypeExecCommand, Body: "say hi"})
+
+	want := []byte{
+		16, 0, 0, 0, // 长度
+		7, 0, 0, 0, // ID
+		2, 0, 0, 0, // 类型
+		's', 'a', 'y', ' ', 'h', 'i', 0, 0,
+	}
+	got := make([]byte, len(want))
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(server, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("encoded packet = %v, want %v", got, want)
+	}
+
+	r := &RconClient{conn: server, reader: bufio.NewReader(server), timeout: time.Second}
+	go client.Write(want)
+	packet, err := r.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet != (rconPacket{ID: 7, Type: rconTypeExecCommand, Body: "say hi"}) {
+		t.Errorf("decoded packet = %+v", packet)
+	}
+}
+
+func TestRconPacketInvalidLength(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	r := &RconClient{conn: server, reader: bufio.NewReader(server), timeout: time.Second}
+	go client.Write([]byte{0xff, 0xff, 0, 0})
+	if _, err := r.readPacket(); err == nil {
+		t.Fatal("readPacket accepted a packet longer than rconMaxPacketSize")
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Repository Restic 仓库及其访问凭证
+type Repository struct {
+	URL      string
+	Password string
+	// 密码文件和获取密码的命令，设置后优先于 Password
+	PasswordFile    string
+	PasswordCommand string
+
+	// AWS/R2 凭证
+	AWSAccessKeyID     string
+	AWSSecretAccessKey string
+	AWSRegion          string
+
+	// 仓库被锁定时的最长等待时间
+	LockWait time.Duration
+	// 仓库不存在时是否自动初始化
+	AutoInit bool
+}
+
+// newRepository 合并全局配置和服务器的 [servers.X.restic]/[servers.X.aws] 配置
+// 服务器配置中未填写的字段沿用全局值；服务器设置了任意一种密码时不再沿用全局的密码设置
+func newRepository(restic, serverRestic ResticConfig, aws, serverAWS AWSConfig) *Repository {
+	fillString(&serverRestic.Repository, restic.Repository)
+	if serverRestic.Password == "" && serverRestic.PasswordFile == "" && serverRestic.PasswordCommand == "" {
+		serverRestic.Password = restic.Password
+		serverRestic.PasswordFile = restic.PasswordFile
+		serverRestic.PasswordCommand = restic.PasswordCommand
+	}
+	// access_key_id 和 secret_access_key 是一组凭证，只填写一个时不沿用全局值，由 validateConfig 报告
+	if serverAWS.AccessKeyID == "" && serverAWS.SecretAccessKey == "" {
+		serverAWS.AccessKeyID = aws.AccessKeyID
+		serverAWS.SecretAccessKey = aws.SecretAccessKey
+	}
+	fillString(&serverAWS.Region, aws.Region)
+
+	// 锁等待时间和自动初始化是运行策略而不是仓库属性，统一使用全局设置
+	lockWait := restic.LockWait
+	if lockWait <= 0 {
+		lockWait = defaultLockWait
+	}
+
+	// restic 不会展开 ~，本地仓库路径在这里展开
+	if strings.HasPrefix(serverRestic.Repository, "~/") {
+		serverRestic.Repository = expandHome(serverRestic.Repository)
+	}
+
+	return &Repository{
+		URL:                serverRestic.Repository,
+		Password:           serverRestic.Password,
+		PasswordFile:       serverRestic.PasswordFile,
+		PasswordCommand:    serverRestic.PasswordCommand,
+		AWSAccessKeyID:     serverAWS.AccessKeyID,
+		AWSSecretAccessKey: serverAWS.SecretAccessKey,
+		AWSRegion:          serverAWS.Region,
+		LockWait:           time.Duration(lockWait) * time.Second,
+		AutoInit:           restic.AutoInit,
+	}
+}
+
+// hasPartialAWSCredentials 判断是否只设置了 access_key_id 和 secret_access_key 中的一个
+func (r *Repository) hasPartialAWSCredentials() bool {
+	return (r.AWSAccessKeyID == "") != (r.AWSSecretAccessKey == "")
+}
+
+// restic 仓库后端类型
+const (
+	backendLocal  = "local"
+	backendSFTP   = "sftp"
+	backendREST   = "rest"
+	backendS3     = "s3"
+	backendB2     = "b2"
+	backendAzure  = "azure"
+	backendGS     = "gs"
+	backendSwift  = "swift"
+	backendRclone = "rclone"
+)
+
+// backend 返回仓库的后端类型（没有前缀的路径为本地仓库）
+func (r *Repository) backend() string {
+	prefix, _, ok := strings.Cut(r.URL, ":")
+	if !ok {
+		return backendLocal
+	}
+	switch prefix {
+	case backendLocal, backendSFTP, backendREST, backendS3, backendB2, backendAzure, backendGS, backendSwift, backendRclone:
+		return prefix
+	default:
+		return backendLocal
+	}
+}
+
+// isLocal 判断是否为本地仓库
+func (r *Repository) isLocal() bool {
+	return r.backend() == backendLocal
+}
+
+// localPath 返回本地仓库的目录
+func (r *Repository) localPath() string {
+	return strings.TrimPrefix(r.URL, backendLocal+":")
+}
+
+// key 返回仓库的唯一标识，地址和凭证都相同的仓库视为同一个仓库
+func (r *Repository) key() string {
+	return strings.Join([]string{r.URL, r.Password, r.PasswordFile, r.PasswordCommand,
+		r.AWSAccessKeyID, r.AWSSecretAccessKey, r.AWSRegion}, "\x00")
+}
+
+// String 返回仓库地址（过长时截断）
+func (r *Repository) String() string {
+	if len(r.URL) > 50 {
+		return r.URL[:50] + "..."
+	}
+	return r.URL
+}
+
+// hasPassword 判断是否配置了任意一种仓库密码
+func (r *Repository) hasPassword() bool {
+	return r.Password != "" || r.PasswordFile != "" || r.PasswordCommand != ""
+}
+
+// resticPassthroughEnv 从当前环境原样传给 restic 的变量
+var resticPassthroughEnv = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "TMPDIR", "TZ", "LANG", "LC_ALL",
+	"XDG_CACHE_HOME", "SSL_CERT_FILE", "SSL_CERT_DIR",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+}
+
+// resticPassthroughPrefixes 从当前环境传给 restic 的变量前缀
+// 包括 restic 自身的选项（如 RESTIC_CACHE_DIR）和其他存储后端的凭证
+var resticPassthroughPrefixes = []string{
+	"RESTIC_", "AWS_", "B2_", "AZURE_", "GOOGLE_", "OS_", "ST_", "RCLONE_",
+}
+
+// resticManagedEnv 由仓库配置决定、不从当前环境继承的变量
+var resticManagedEnv = map[string]bool{
+	"RESTIC_REPOSITORY":       true,
+	"RESTIC_REPOSITORY_FILE":  true,
+	"RESTIC_PASSWORD":         true,
+	"RESTIC_PASSWORD_FILE":    true,
+	"RESTIC_PASSWORD_COMMAND": true,
+
+	// restic copy 的源仓库
+	"RESTIC_FROM_REPOSITORY":       true,
+	"RESTIC_FROM_REPOSITORY_FILE":  true,
+	"RESTIC_FROM_PASSWORD":         true,
+	"RESTIC_FROM_PASSWORD_FILE":    true,
+	"RESTIC_FROM_PASSWORD_COMMAND": true,
+}
+
+// env 返回访问该仓库的 restic 进程的完整环境变量
+// 只包含 restic 需要的变量，凭证不会出现在其他子进程（docker、ping 等）的环境中
+func (r *Repository) env() []string {
+	var env []string
+	for _, item := range os.Environ() {
+		key, _, _ := strings.Cut(item, "=")
+		if resticManagedEnv[key] {
+			continue
+		}
+		if r.AWSAccessKeyID != "" && (key == "AWS_ACCESS_KEY_ID" || key == "AWS_SECRET_ACCESS_KEY" || key == "AWS_SESSION_TOKEN") {
+			continue
+		}
+		if r.AWSRegion != "" && key == "AWS_DEFAULT_REGION" {
+			continue
+		}
+		if isResticPassthroughEnv(key) {
+			env = append(env, item)
+		}
+	}
+
+	env = append(env, "RESTIC_REPOSITORY="+r.URL)
+	// 优先通过密码命令或密码文件传递，避免密码出现在进程环境中
+	switch {
+	case r.PasswordCommand != "":
+		env = append(env, "RESTIC_PASSWORD_COMMAND="+r.PasswordCommand)
+	case r.PasswordFile != "":
+		env = append(env, "RESTIC_PASSWORD_FILE="+r.PasswordFile)
+	default:
+		env = append(env, "RESTIC_PASSWORD="+r.Password)
+	}
+
+	if r.AWSAccessKeyID != "" {
+		env = append(env, "AWS_ACCESS_KEY_ID="+r.AWSAccessKeyID, "AWS_SECRET_ACCESS_KEY="+r.AWSSecretAccessKey)
+	}
+	if r.AWSRegion != "" {
+		env = append(env, "AWS_DEFAULT_REGION="+r.AWSRegion)
+	}
+	return env
+}
+
+// isResticPassthroughEnv 判断当前环境中的变量是否需要传给 restic
+func isResticPassthroughEnv(key string) bool {
+	for _, name := range resticPassthroughEnv {
+		if key == name {
+			return true
+		}
+	}
+	if strings.HasPrefix(key, "LC_") {
+		return true
+	}
+	for _, prefix := range resticPassthroughPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// command 创建访问该仓库的 restic 命令
+// 凭证只通过该进程自己的环境变量传递，不修改当前进程的环境，
+// 并行备份到不同仓库时互不影响
+// ctx 取消时向 restic 发送 SIGINT，让它删除自己创建的锁后退出，超过 resticStopTimeout 后强制结束
+func (r *Repository) command(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "restic", args...)
+	cmd.Env = r.env()
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = resticStopTimeout
+	return cmd
+}
+
+// restic 的退出码（10 及以上需要 restic 0.17 及以上版本）
+const (
+	resticExitIncomplete        = 3
+	resticExitRepositoryMissing = 10
+	resticExitLockFailed        = 11
+	resticExitWrongPassword     = 12
+)
+
+// resticExitCode 返回 restic 命令的退出码，命令未能运行时返回 -1
+func resticExitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// resticError 将 restic 的错误输出（前 3 行）附加到错误信息中
+func resticError(err error, stderr string) error {
+	var lines []string
+	for _, line := range strings.Split(stderr, "\n") {
+		if line = strings.TrimSpace(line); line != "" && len(lines) < 3 {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > 0 {
+		return fmt.Errorf("%v: %s", err, strings.Join(lines, "; "))
+	}
+	return err
+}
+
+// repositoryExists 判断仓库是否已经初始化
+// 只有 restic 明确报告仓库不存在（退出码 10）时才返回 false，其他错误（密码错误、网络问题等）返回 error
+func repositoryExists(ctx context.Context, repo *Repository) (bool, error) {
+	cmd := repo.command(ctx, "cat", "config", "--no-lock")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	switch {
+	case err == nil:
+		return true, nil
+	case resticExitCode(err) == resticExitRepositoryMissing:
+		return false, nil
+	case resticExitCode(err) == resticExitWrongPassword:
+		return false, fmt.Errorf("仓库密码错误")
+	default:
+		return false, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+}
+
+// initRepository 初始化仓库
+// from 不为空时使用 --copy-chunker-params 复用源仓库的分块参数，restic copy 复制到该仓库时可以去重
+func initRepository(ctx context.Context, repo *Repository, from *Repository) error {
+	args := []string{"init"}
+	var extraEnv []string
+	if from != nil {
+		args = append(args, "--copy-chunker-params")
+		extraEnv = copyEnv(from)
+	}
+
+	cmd := repo.command(ctx, args...)
+	cmd.Env = append(cmd.Env, extraEnv...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// repositoriesOf 返回服务器使用的所有仓库（去重，按地址排序）
+func repositoriesOf(multiConfig *MultiServerConfig) []*Repository {
+	seen := make(map[string]bool)
+	var repos []*Repository
+	for _, serverName := range sortedServerNames(multiConfig) {
+		repo := multiConfig.Servers[serverName].Repository
+		if seen[repo.key()] {
+			continue
+		}
+		seen[repo.key()] = true
+		repos = append(repos, repo)
+	}
+
+	sort.SliceStable(repos, func(i, j int) bool {
+		return repos[i].URL < repos[j].URL
+	})
+	return repos
+}
+
+// checkRepositories 检查服务器使用的所有仓库的连接
+// 镜像仓库不可用时只记录警告，不影响主仓库的备份
+func checkRepositories(ctx context.Context, multiConfig *MultiServerConfig) error {
+	for _, repo := range repositoriesOf(multiConfig) {
+		logger.Log("验证 Restic 仓库连接: %s", repo)
+		if err := checkRepositoryConnection(ctx, repo); err != nil {
+			return err
+		}
+	}
+	for _, mirror := range multiConfig.Mirrors {
+		logger.Log("验证镜像仓库连接: %s (%s)", mirror.Name, mirror.Repository)
+		if err := checkRepositoryConnection(ctx, mirror.Repository); err != nil {
+			logger.Log("警告: 镜像仓库 %s 不可用: %v", mirror.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewRepositoryAWSCredentials(t *testing.T) {
+	restic := ResticConfig{Repository: "s3:https://r2.example.com/minecraft", Password: "secret"}
+	global := AWSConfig{AccessKeyID: "global-id", SecretAccessKey: "global-secret", Region: "auto"}
+
+	tests := []struct {
+		name       string
+		server     AWSConfig
+		wantID     string
+		wantSecret string
+		partial    bool
+	}{
+		{"inherit both", AWSConfig{}, "global-id", "global-secret", false},
+		{"own pair", AWSConfig{AccessKeyID: "staff-id", SecretAccessKey: "staff-secret"}, "staff-id", "staff-secret", false},
+		// 只填写一个时不能与全局的另一个拼成一组
+		{"only access key", AWSConfig{AccessKeyID: "staff-id"}, "staff-id", "", true},
+		{"only secret", AWSConfig{SecretAccessKey: "staff-secret"}, "", "staff-secret", true},
+		{"only region", AWSConfig{Region: "us-east-1"}, "global-id", "global-secret", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRepository(restic, ResticConfig{}, global, tt.server)
+			if repo.AWSAccessKeyID != tt.wantID || repo.AWSSecretAccessKey != tt.wantSecret {
+				t.Errorf("credentials = %q/%q, want %q/%q", repo.AWSAccessKeyID, repo.AWSSecretAccessKey, tt.wantID, tt.wantSecret)
+			}
+			if got := repo.hasPartialAWSCredentials(); got != tt.partial {
+				t.Errorf("hasPartialAWSCredentials() = %v, want %v", got, tt.partial)
+			}
+		})
+	}
+}
+
+func TestValidateConfigPartialAWSCredentials(t *testing.T) {
+	repo := newRepository(ResticConfig{Repository: "s3:https://r2.example.com/staff", Password: "secret"}, ResticConfig{},
+		AWSConfig{AccessKeyID: "global-id", SecretAccessKey: "global-secret"}, AWSConfig{AccessKeyID: "staff-id"})
+	multi := &MultiServerConfig{
+		RunLock:    runLockWait,
+		Repository: repo,
+		Servers: map[string]*Config{
+			"staff": {Type: serverTypeRcon, RconPassword: "secret", WorldDir: t.TempDir(), Repository: repo},
+		},
+	}
+
+	problems := validateConfig(multi)
+	found := false
+	for _, problem := range problems {
+		if strings.Contains(problem, "[servers.staff] AWS 凭证不完整") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("validateConfig() = %q, want a problem about the incomplete AWS credentials", problems)
+	}
+}
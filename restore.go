@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshot restic 快照信息（restic snapshots --json 的输出）
+type Snapshot struct {
+	ID       string    `json:"id"`
+	ShortID  string    `json:"short_id"`
+	Time     time.Time `json:"time"`
+	Hostname string    `json:"hostname"`
+	Tags     []string  `json:"tags"`
+	Paths    []string  `json:"paths"`
+}
+
+// RestoreOptions 恢复命令参数
+type RestoreOptions struct {
+	ServerName string
+	// 快照 ID（支持短 ID 前缀）或 "latest"
+	SnapshotID string
+	// 恢复不晚于该时间点的最新快照
+	At time.Time
+	// 只显示将要恢复的快照
+	DryRun bool
+}
+
+// restoreTimeLayouts --at 参数支持的时间格式（本地时区）
+var restoreTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// parseRestoreTime 解析 --at 参数
+func parseRestoreTime(value string) (time.Time, error) {
+	for _, layout := range restoreTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("无法解析时间: %s（示例: \"2024-01-01 10:00\"）", value)
+}
+
+// parseRestoreArgs 解析 restore 子命令参数
+// 用法: restore <server> [snapshot-id|latest] [--at <time>]
+func parseRestoreArgs(globalOpts *GlobalOptions, args []string) (*RestoreOptions, error) {
+	fs := newFlagSet("restore", globalOpts)
+	at := fs.String("at", "", "恢复不晚于该时间点的最新快照")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(positional) == 0 || len(positional) > 2 {
+		return nil, usageError("用法: restore <server> [snapshot-id|latest] [--at <time>]")
+	}
+
+	opts := &RestoreOptions{
+		ServerName: positional[0],
+		SnapshotID: "latest",
+		DryRun:     globalOpts.DryRun,
+	}
+	if len(positional) == 2 {
+		opts.SnapshotID = positional[1]
+	}
+
+	if *at != "" {
+		if len(positional) == 2 && opts.SnapshotID != "latest" {
+			return nil, usageError("不能同时指定快照 ID 和 --at")
+		}
+		t, err := parseRestoreTime(*at)
+		if err != nil {
+			return nil, err
+		}
+		opts.At = t
+	}
+
+	return opts, nil
+}
+
+// listSnapshots 列出指定主机和标签的快照（按时间升序）
+func listSnapshots(ctx context.Context, repo *Repository, host, tag string) ([]Snapshot, error) {
+	args := []string{"snapshots", "--json", "--no-lock"}
+	if host != "" {
+		args = append(args, "--host", host)
+	}
+	if tag != "" {
+		args = append(args, "--tag", tag)
+	}
+
+	cmd := repo.command(ctx, args...)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("获取快照列表失败: %v", err)
+	}
+
+	var snapshots []Snapshot
+	if err := json.Unmarshal(output, &snapshots); err != nil {
+		return nil, fmt.Errorf("解析快照列表失败: %v", err)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.Before(snapshots[j].Time)
+	})
+
+	return snapshots, nil
+}
+
+// selectSnapshot 根据恢复参数从快照列表中选出目标快照
+func selectSnapshot(snapshots []Snapshot, opts *RestoreOptions) (*Snapshot, error) {
+	if len(snapshots) == 0 {
+		return nil, fmt.Errorf("没有找到任何快照")
+	}
+
+	if !opts.At.IsZero() {
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			if !snapshots[i].Time.After(opts.At) {
+				return &snapshots[i], nil
+			}
+		}
+		return nil, fmt.Errorf("没有早于 %s 的快照", opts.At.Format("2006-01-02 15:04:05"))
+	}
+
+	if opts.SnapshotID == "" || opts.SnapshotID == "latest" {
+		return &snapshots[len(snapshots)-1], nil
+	}
+
+	var matched []*Snapshot
+	for i := range snapshots {
+		if strings.HasPrefix(snapshots[i].ID, opts.SnapshotID) {
+			matched = append(matched, &snapshots[i])
+		}
+	}
+
+	switch len(matched) {
+	case 0:
+		return nil, fmt.Errorf("快照 %s 不存在或不属于该服务器", opts.SnapshotID)
+	case 1:
+		return matched[0], nil
+	default:
+		return nil, fmt.Errorf("快照 ID %s 不唯一，请提供更长的 ID", opts.SnapshotID)
+	}
+}
+
+// restoreToStaging 将快照中的世界目录恢复到临时目录
+func restoreToStaging(ctx context.Context, repo *Repository, snapshot *Snapshot, stagingDir string) error {
+	if len(snapshot.Paths) == 0 {
+		return fmt.Errorf("快照 %s 不包含任何路径", snapshot.ShortID)
+	}
+
+	// 使用 <snapshot>:<path> 语法，直接把世界目录的内容恢复到目标目录
+	cmd := repo.command(ctx, "restore",
+		fmt.Sprintf("%s:%s", snapshot.ID, snapshot.Paths[0]),
+		"--target", stagingDir)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// swapWorldDir 用恢复出的目录替换世界目录，旧目录保留为回滚副本
+// 返回实际使用的回滚目录（原世界目录不存在时为空）
+func swapWorldDir(worldDir, stagingDir, rollbackDir string) (string, error) {
+	if _, err := os.Stat(worldDir); err == nil {
+		if err := os.Rename(worldDir, rollbackDir); err != nil {
+			return "", fmt.Errorf("无法移动旧世界目录: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	} else {
+		rollbackDir = ""
+	}
+
+	if err := os.Rename(stagingDir, worldDir); err != nil {
+		// 尝试把旧世界目录放回原处
+		if rollbackDir != "" {
+			if rerr := os.Rename(rollbackDir, worldDir); rerr != nil {
+				logger.Log("错误: 无法还原旧世界目录，请手动将 %s 移回 %s", rollbackDir, worldDir)
+			}
+		}
+		return "", fmt.Errorf("无法替换世界目录: %v", err)
+	}
+
+	return rollbackDir, nil
+}
+
+// restoreServer 将快照恢复到服务器的世界目录
+func restoreServer(ctx context.Context, multiConfig *MultiServerConfig, opts *RestoreOptions) error {
+	config, ok := multiConfig.Servers[opts.ServerName]
+	if !ok {
+		return fmt.Errorf("服务器 %s 不存在", opts.ServerName)
+	}
+	if err := resolveWorldDir(ctx, opts.ServerName, config); err != nil {
+		return fmt.Errorf("服务器 %s: %v", opts.ServerName, err)
+	}
+
+	// 查找快照
+	snapshots, err := listSnapshots(ctx, config.Repository, config.BackupHost, config.BackupTag)
+	if err != nil {
+		return err
+	}
+	snapshot, err := selectSnapshot(snapshots, opts)
+	if err != nil {
+		return fmt.Errorf("服务器 %s: %v", opts.ServerName, err)
+	}
+
+	logger.Log("[%s] 准备恢复快照 %s（%s，主机 %s）", opts.ServerName,
+		snapshot.ShortID, snapshot.Time.Local().Format("2006-01-02 15:04:05"), snapshot.Hostname)
+
+	if opts.DryRun {
+		logger.Log("[dry-run] 将恢复到: %s", config.WorldDir)
+		return nil
+	}
+
+	// 临时目录和回滚目录放在世界目录旁边，保证 rename 在同一文件系统内完成
+	worldDir := filepath.Clean(config.WorldDir)
+	timestamp := time.Now().Format("20060102-150405")
+	stagingDir := fmt.Sprintf("%s.restore-%s", worldDir, timestamp)
+	rollbackDir := fmt.Sprintf("%s.rollback-%s", worldDir, timestamp)
+
+	// 先恢复到临时目录，此时服务器仍可继续运行
+	logger.Log("[%s] 恢复快照到临时目录: %s", opts.ServerName, stagingDir)
+	if err := restoreToStaging(ctx, config.Repository, snapshot, stagingDir); err != nil {
+		os.RemoveAll(stagingDir)
+		return fmt.Errorf("服务器 %s: 恢复快照失败: %v", opts.ServerName, err)
+	}
+
+	// 停止服务器
+	controller, err := controllerFor(config)
+	if err != nil {
+		return fmt.Errorf("服务器 %s: %v", opts.ServerName, err)
+	}
+	running, err := controller.IsRunning(ctx)
+	if err != nil {
+		return fmt.Errorf("服务器 %s: 无法检查运行状态: %v", opts.ServerName, err)
+	}
+	if running {
+		logger.Log("[%s] 停止%s...", opts.ServerName, controller)
+		if err := controller.Stop(ctx); err != nil {
+			if errors.Is(err, errUnsupported) {
+				return fmt.Errorf("服务器 %s: 无法自动停止%s，请先手动停止服务器再恢复（恢复出的数据保留在 %s）", opts.ServerName, controller, stagingDir)
+			}
+			return fmt.Errorf("服务器 %s: 无法停止%s: %v（恢复出的数据保留在 %s）", opts.ServerName, controller, err, stagingDir)
+		}
+	} else {
+		logger.Log("[%s] %s 未运行，直接替换世界目录", opts.ServerName, controller)
+	}
+
+	// 替换世界目录
+	keptDir, swapErr := swapWorldDir(worldDir, stagingDir, rollbackDir)
+	if swapErr == nil {
+		if keptDir != "" {
+			logger.Log("[%s] 世界目录已替换，旧世界保留在: %s", opts.ServerName, keptDir)
+		} else {
+			logger.Log("[%s] 世界目录已创建: %s", opts.ServerName, worldDir)
+		}
+	}
+
+	// 无论替换是否成功、是否收到中断信号都重新启动原本运行的服务器
+	if running {
+		logger.Log("[%s] 启动%s...", opts.ServerName, controller)
+		if err := controller.Start(context.WithoutCancel(ctx)); err != nil {
+			logger.Log("警告: 服务器 %s 无法启动，请手动检查: %v", opts.ServerName, err)
+		}
+	}
+
+	if swapErr != nil {
+		return fmt.Errorf("服务器 %s: %v", opts.ServerName, swapErr)
+	}
+
+	logger.Log("[%s] 恢复完成", opts.ServerName)
+	return nil
+}
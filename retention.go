@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// keepWithinPattern restic --keep-within 的时长格式，例如 1y5m7d2h
+var keepWithinPattern = regexp.MustCompile(`^([0-9]+[ymdh])+$`)
+
+// retentionKeysDefined 返回一个函数，判断 [servers.X.retention] 中是否填写了某个字段
+func retentionKeysDefined(meta toml.MetaData, serverName string) func(key string) bool {
+	return func(key string) bool {
+		return meta.IsDefined("servers", serverName, "retention", key)
+	}
+}
+
+// mergeRetention 合并全局保留策略和服务器保留策略
+// 服务器策略中填写了的字段（包括显式填写 0）覆盖全局值，其余字段沿用全局值
+func mergeRetention(global, server RetentionConfig, defined func(key string) bool) RetentionConfig {
+	merged := global
+	if defined("keep_last") {
+		merged.KeepLast = server.KeepLast
+	}
+	if defined("keep_hourly") {
+		merged.KeepHourly = server.KeepHourly
+	}
+	if defined("keep_daily") {
+		merged.KeepDaily = server.KeepDaily
+	}
+	if defined("keep_weekly") {
+		merged.KeepWeekly = server.KeepWeekly
+	}
+	if defined("keep_monthly") {
+		merged.KeepMonthly = server.KeepMonthly
+	}
+	if defined("keep_yearly") {
+		merged.KeepYearly = server.KeepYearly
+	}
+	if defined("keep_within") {
+		merged.KeepWithin = server.KeepWithin
+	}
+	return merged
+}
+
+// isEmpty 判断是否未设置任何保留规则
+func (r RetentionConfig) isEmpty() bool {
+	return r == RetentionConfig{}
+}
+
+// validate 检查保留策略的取值
+func (r RetentionConfig) validate() error {
+	counts := map[string]int{
+		"keep_last":    r.KeepLast,
+		"keep_hourly":  r.KeepHourly,
+		"keep_daily":   r.KeepDaily,
+		"keep_weekly":  r.KeepWeekly,
+		"keep_monthly": r.KeepMonthly,
+		"keep_yearly":  r.KeepYearly,
+	}
+	for key, value := range counts {
+		if value < 0 {
+			return fmt.Errorf("%s 不能为负数: %d", key, value)
+		}
+	}
+	if r.KeepWithin != "" && !keepWithinPattern.MatchString(r.KeepWithin) {
+		return fmt.Errorf("keep_within 格式无效: %s（单位为 y、m、d、h，例如 7d、1y6m）", r.KeepWithin)
+	}
+	return nil
+}
+
+// args 返回 restic forget 的保留参数（未设置的规则不传）
+func (r RetentionConfig) args() []string {
+	var args []string
+	add := func(flag string, value int) {
+		if value > 0 {
+			args = append(args, flag, strconv.Itoa(value))
+		}
+	}
+	add("--keep-last", r.KeepLast)
+	add("--keep-hourly", r.KeepHourly)
+	add("--keep-daily", r.KeepDaily)
+	add("--keep-weekly", r.KeepWeekly)
+	add("--keep-monthly", r.KeepMonthly)
+	add("--keep-yearly", r.KeepYearly)
+	if r.KeepWithin != "" {
+		args = append(args, "--keep-within", r.KeepWithin)
+	}
+	return args
+}
+
+// String 返回保留策略的简短描述
+func (r RetentionConfig) String() string {
+	var parts []string
+	add := func(name string, value int) {
+		if value > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d 个", name, value))
+		}
+	}
+	add("最近", r.KeepLast)
+	add("每小时", r.KeepHourly)
+	add("每日", r.KeepDaily)
+	add("每周", r.KeepWeekly)
+	add("每月", r.KeepMonthly)
+	add("每年", r.KeepYearly)
+	if r.KeepWithin != "" {
+		parts = append(parts, "最近 "+r.KeepWithin+" 内全部")
+	}
+	if len(parts) == 0 {
+		return "未设置"
+	}
+	return strings.Join(parts, "，")
+}
+
+// ForgetResult 单个服务器的快照清理结果
+type ForgetResult struct {
+	ServerName string
+	// 镜像名称（主仓库为空）
+	Mirror string
+	Host   string
+	Tag    string
+	// 删除（dry-run 时为将要删除）的快照数量
+	Removed int
+	// 保留的快照数量
+	Kept int
+	// 未设置保留策略，未执行 forget
+	Skipped bool
+	Err     error
+}
+
+// resticForgetGroup restic forget --json 输出中的一个快照分组
+type resticForgetGroup struct {
+	Host   string     `json:"host"`
+	Tags   []string   `json:"tags"`
+	Keep   []Snapshot `json:"keep"`
+	Remove []Snapshot `json:"remove"`
+}
+
+// cleanupSnapshots 按每个服务器的保留策略清理旧快照
+// 先对每个服务器（按主机和标签分组）执行 forget，全部完成后每个仓库只执行一次 prune，
+// 避免多次重写仓库数据；镜像仓库按镜像自己的保留策略清理
+// dryRun 为 true 时只显示将被删除的快照
+func cleanupSnapshots(ctx context.Context, multiConfig *MultiServerConfig, dryRun bool) error {
+	logger.Log("开始清理旧快照...")
+
+	var results []ForgetResult
+	removedByRepo := make(map[string]int)
+	failed := 0
+	forget := func(serverName, mirrorName string, config *Config) {
+		result := forgetSnapshots(ctx, serverName, config, dryRun)
+		result.Mirror = mirrorName
+		results = append(results, result)
+		removedByRepo[config.Repository.key()] += result.Removed
+		if result.Err != nil {
+			failed++
+		}
+	}
+
+	repos := repositoriesOf(multiConfig)
+	for _, serverName := range sortedServerNames(multiConfig) {
+		forget(serverName, "", multiConfig.Servers[serverName])
+	}
+	for _, mirror := range multiConfig.Mirrors {
+		logger.Log("清理镜像 %s 的快照...", mirror.Name)
+		for _, serverName := range sortedServerNames(multiConfig) {
+			mirrorConfig := *multiConfig.Servers[serverName]
+			mirrorConfig.Repository = mirror.Repository
+			mirrorConfig.Retention = mirror.Retention
+			forget(serverName, mirror.Name, &mirrorConfig)
+		}
+		repos = append(repos, mirror.Repository)
+	}
+
+	pruneFailed := 0
+	for _, repo := range repos {
+		removed := removedByRepo[repo.key()]
+		switch {
+		case removed == 0:
+			logger.Log("仓库 %s 没有需要删除的快照，跳过 prune", repo)
+		case dryRun:
+			logger.Log("[dry-run] 仓库 %s 将删除 %d 个快照，然后执行一次 restic prune", repo, removed)
+		default:
+			logger.Log("仓库 %s 共删除 %d 个快照，开始清理不再使用的数据...", repo, removed)
+			if err := pruneRepository(ctx, repo, multiConfig.PruneTimeout); err != nil {
+				logger.Log("警告: restic prune 失败: %v", err)
+				pruneFailed++
+			} else {
+				logger.Log("仓库清理完成")
+			}
+		}
+	}
+
+	showForgetSummary(results, dryRun)
+
+	if failed > 0 {
+		return fmt.Errorf("%d 个服务器的快照清理失败", failed)
+	}
+	if pruneFailed > 0 {
+		return fmt.Errorf("%d 个仓库的 restic prune 失败", pruneFailed)
+	}
+	return nil
+}
+
+// forgetSnapshots 按服务器的保留策略对其主机和标签下的快照执行 restic forget（不执行 prune）
+func forgetSnapshots(ctx context.Context, serverName string, config *Config, dryRun bool) ForgetResult {
+	result := ForgetResult{ServerName: serverName, Host: config.BackupHost, Tag: config.BackupTag}
+	if config.Retention.isEmpty() {
+		logger.Log("服务器 %s 未设置保留策略，跳过清理", serverName)
+		result.Skipped = true
+		return result
+	}
+
+	logger.Log("清理服务器 %s 的快照（主机 %s，标签 %s，%s）...", serverName, config.BackupHost, config.BackupTag, config.Retention)
+
+	args := []string{"forget", "--json", "--group-by", "host,tags",
+		"--host", config.BackupHost, "--tag", config.BackupTag}
+	args = append(args, config.Retention.args()...)
+	if dryRun {
+		args = append(args, "--dry-run")
+	}
+
+	forgetCtx, cancel := context.WithTimeout(ctx, config.ForgetTimeout)
+	defer cancel()
+	output, err := runResticWithLockWait(forgetCtx, config.Repository, nil, args...)
+	if err != nil {
+		if ctx.Err() == nil && forgetCtx.Err() != nil {
+			err = fmt.Errorf("restic forget 超过 %s 未完成（forget_timeout），已中止", config.ForgetTimeout)
+		}
+		logger.Log("警告: 服务器 %s 的快照清理失败: %v", serverName, err)
+		result.Err = err
+		return result
+	}
+
+	var groups []resticForgetGroup
+	if err := json.Unmarshal(output, &groups); err != nil {
+		result.Err = fmt.Errorf("解析 restic forget 输出失败: %v", err)
+		logger.Log("警告: %v", result.Err)
+		return result
+	}
+	for _, group := range groups {
+		result.Kept += len(group.Keep)
+		result.Removed += len(group.Remove)
+		if dryRun {
+			for _, snapshot := range group.Remove {
+				logger.Log("  [dry-run] 将删除快照 %s (%s)", snapshot.ShortID, snapshot.Time.Local().Format("2006-01-02 15:04:05"))
+			}
+		}
+	}
+	return result
+}
+
+// pruneRepository 对仓库执行 restic prune，超过 timeout 时中止
+func pruneRepository(ctx context.Context, repo *Repository, timeout time.Duration) error {
+	pruneCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	_, err := runResticWithLockWait(pruneCtx, repo, nil, "prune")
+	if err != nil && ctx.Err() == nil && pruneCtx.Err() != nil {
+		return fmt.Errorf("restic prune 超过 %s 未完成（prune_timeout），已中止", timeout)
+	}
+	return err
+}
+
+// runResticWithLockWait 执行 restic 命令并返回 stdout
+// 仓库被锁定时删除过期的锁，或等待其他进程释放锁后重试（最多等待 lock_wait）
+// extraEnv 为额外传给 restic 的环境变量（如 restic copy 的源仓库）
+func runResticWithLockWait(ctx context.Context, repo *Repository, extraEnv []string, args ...string) ([]byte, error) {
+	waiter := newLockWaiter(repo)
+
+	for {
+		cmd := repo.command(ctx, args...)
+		cmd.Env = append(cmd.Env, extraEnv...)
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		logger.Debug("执行: restic %s", strings.Join(args, " "))
+
+		output, err := cmd.Output()
+		if err == nil {
+			return output, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		stderrStr := stderr.String()
+		if isRepositoryLocked(stderrStr) || resticExitCode(err) == resticExitLockFailed {
+			logger.Log("仓库 %s 被锁定，检查锁的持有者...", repo)
+			if err := waiter.wait(ctx); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		return nil, resticError(err, stderrStr)
+	}
+}
+
+// showForgetSummary 显示每个服务器的快照清理结果
+func showForgetSummary(results []ForgetResult, dryRun bool) {
+	removedLabel := "删除"
+	if dryRun {
+		removedLabel = "将删除"
+	}
+
+	logger.Log("快照清理摘要:")
+	for _, result := range results {
+		name := result.ServerName
+		if result.Mirror != "" {
+			name = fmt.Sprintf("%s（镜像 %s）", result.ServerName, result.Mirror)
+		}
+		switch {
+		case result.Skipped:
+			logger.Log("  %s: 未设置保留策略，已跳过", name)
+		case result.Err != nil:
+			logger.Log("  %s: 失败 (%v)", name, result.Err)
+		default:
+			logger.Log("  %s: %s %d 个快照，保留 %d 个", name, removedLabel, result.Removed, result.Kept)
+		}
+	}
+}
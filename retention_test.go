@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestMergeRetention(t *testing.T) {
+	const config = `
+[retention]
+keep_last = 10
+keep_daily = 7
+keep_weekly = 4
+keep_within = "2d"
+
+[servers.survival.retention]
+keep_daily = 0
+keep_monthly = 6
+keep_within = ""
+
+[servers.creative]
+`
+	var tomlConfig TOMLConfig
+	meta, err := toml.Decode(config, &tomlConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merge := func(serverName string) RetentionConfig {
+		return mergeRetention(tomlConfig.Retention, tomlConfig.Servers[serverName].Retention, retentionKeysDefined(meta, serverName))
+	}
+
+	// 显式填写的 0 和空字符串覆盖全局值，未填写的字段沿用全局值
+	want := RetentionConfig{KeepLast: 10, KeepDaily: 0, KeepWeekly: 4, KeepMonthly: 6, KeepWithin: ""}
+	if got := merge("survival"); got != want {
+		t.Errorf("survival retention = %+v, want %+v", got, want)
+	}
+
+	// 没有 [servers.X.retention] 时完全使用全局策略
+	if got := merge("creative"); got != tomlConfig.Retention {
+		t.Errorf("creative retention = %+v, want the global policy %+v", got, tomlConfig.Retention)
+	}
+}
+
+func TestMergeRetentionAllZero(t *testing.T) {
+	global := RetentionConfig{KeepLast: 10, KeepDaily: 7}
+	all := func(string) bool { return true }
+
+	// 所有字段都显式填写为 0 时服务器没有保留规则，清理时跳过该服务器
+	merged := mergeRetention(global, RetentionConfig{}, all)
+	if !merged.isEmpty() {
+		t.Errorf("merged = %+v, want an empty policy", merged)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runLockFile 运行锁文件名（位于状态目录中）
+const runLockFile = "run.lock"
+
+// run_lock 可选值：运行锁被其他实例持有时的处理方式
+const (
+	runLockSkip = "skip"
+	runLockWait = "wait"
+	runLockFail = "fail"
+)
+
+const (
+	// defaultRunLockWait run_lock = "wait" 时默认的最长等待时间
+	defaultRunLockWait = 30 * time.Minute
+
+	// runLockPollInterval 等待运行锁时的检查间隔
+	runLockPollInterval = 5 * time.Second
+)
+
+// errRunLockSkipped 其他实例正在运行且 run_lock = "skip"，本次不执行
+var errRunLockSkipped = errors.New("另一个实例正在运行，跳过本次执行")
+
+// runLockHolder 写入运行锁文件的持有者信息
+type runLockHolder struct {
+	PID     int       `json:"pid"`
+	Command string    `json:"command"`
+	Started time.Time `json:"started"`
+}
+
+// String 返回持有者描述
+func (h *runLockHolder) String() string {
+	if h == nil || h.PID == 0 {
+		return "未知进程"
+	}
+	if h.Started.IsZero() {
+		return fmt.Sprintf("进程 %d", h.PID)
+	}
+	return fmt.Sprintf("进程 %d（%s 开始执行 %s，已运行 %s）", h.PID,
+		h.Started.Local().Format("2006-01-02 15:04:05"), h.Command, time.Since(h.Started).Round(time.Second))
+}
+
+// runLock 主机级别的运行锁（状态目录中 run.lock 上的 flock）
+// 防止定时任务触发时上一次备份仍在运行，两个实例同时执行 save-off/save-on 并争用仓库锁
+// 进程退出（包括被 SIGKILL）时内核自动释放 flock，不会留下过期的锁
+type runLock struct {
+	file *os.File
+}
+
+// runLockPath 返回运行锁文件路径
+func runLockPath() string {
+	return filepath.Join(stateDir(), runLockFile)
+}
+
+// tryFlock 以非阻塞方式获取文件锁，锁被占用时返回 false
+func tryFlock(file *os.File, how int) (bool, error) {
+	err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// readRunLockHolder 读取锁文件中的持有者信息
+func readRunLockHolder(file *os.File) *runLockHolder {
+	data, err := io.ReadAll(io.NewSectionReader(file, 0, 1<<16))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	var holder runLockHolder
+	if err := json.Unmarshal(data, &holder); err != nil {
+		return nil
+	}
+	return &holder
+}
+
+// acquireRunLock 获取运行锁，锁被其他实例持有时按 run_lock 处理：
+// "skip" 返回 errRunLockSkipped，"wait" 最多等待 run_lock_wait，"fail" 直接返回错误
+func acquireRunLock(ctx context.Context, multiConfig *MultiServerConfig, command string) (*runLock, error) {
+	dir := stateDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("无法创建状态目录 %s: %v", dir, err)
+	}
+	file, err := os.OpenFile(runLockPath(), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("无法打开运行锁: %v", err)
+	}
+
+	var deadline time.Time
+	for {
+		acquired, err := tryFlock(file, syscall.LOCK_EX)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("无法获取运行锁: %v", err)
+		}
+		if acquired {
+			break
+		}
+
+		holder := readRunLockHolder(file)
+		switch multiConfig.RunLock {
+		case runLockSkip:
+			file.Close()
+			logger.Log("%s正在运行，按 run_lock = \"skip\" 跳过本次执行", holder)
+			return nil, errRunLockSkipped
+		case runLockFail:
+			file.Close()
+			return nil, fmt.Errorf("%s正在运行（run_lock = \"fail\"）", holder)
+		}
+
+		if deadline.IsZero() {
+			deadline = time.Now().Add(multiConfig.RunLockWait)
+			logger.Log("%s正在运行，等待其结束（最多 %s）...", holder, multiConfig.RunLockWait)
+		}
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, fmt.Errorf("等待 %s 后%s仍在运行（run_lock_wait）", multiConfig.RunLockWait, holder)
+		}
+		if err := sleepContext(ctx, runLockPollInterval); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	if !deadline.IsZero() {
+		logger.Log("其他实例已结束，继续执行")
+	}
+
+	holder := runLockHolder{PID: os.Getpid(), Command: command, Started: time.Now()}
+	data, _ := json.Marshal(holder)
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt(data, 0)
+	}
+	logger.Debug("已获取运行锁 %s", runLockPath())
+	return &runLock{file: file}, nil
+}
+
+// release 释放运行锁
+func (l *runLock) release() {
+	l.file.Truncate(0)
+	l.file.Close()
+}
+
+// runLockStatus 返回当前持有运行锁的进程，没有实例运行时返回 nil
+// 只读取锁文件和 /proc/locks，不会获取锁：查看状态时不能让同时启动的备份误以为锁被占用
+func runLockStatus() (*runLockHolder, error) {
+	path := runLockPath()
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var holder *runLockHolder
+	if file, err := os.Open(path); err == nil {
+		holder = readRunLockHolder(file)
+		file.Close()
+	}
+
+	pid, err := flockHolder(info)
+	if err != nil {
+		// 无法读取 /proc/locks 时根据记录的进程是否存在判断
+		if holder != nil && holder.PID != os.Getpid() && processExists(holder.PID) {
+			return holder, nil
+		}
+		return nil, nil
+	}
+	if pid == 0 {
+		return nil, nil
+	}
+	if holder == nil || holder.PID != pid {
+		// 持有者刚获取锁，还没有写入信息
+		return &runLockHolder{PID: pid}, nil
+	}
+	return holder, nil
+}
+
+// flockHolder 在 /proc/locks 中查找文件上的 flock，返回持有锁的进程，没有锁时返回 0
+func flockHolder(info os.FileInfo) (int, error) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("无法获取 %s 的 inode", info.Name())
+	}
+	data, err := os.ReadFile("/proc/locks")
+	if err != nil {
+		return 0, err
+	}
+
+	// 格式: "1: FLOCK  ADVISORY  WRITE 1234 08:01:5678 0 EOF"，设备号为十六进制的 主:次
+	dev := uint64(stat.Dev)
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+	file := fmt.Sprintf("%02x:%02x:%d", major, minor, stat.Ino)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		// 等待中的锁以 "->" 标记，跳过
+		if len(fields) < 6 || fields[1] != "FLOCK" || fields[5] != file {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[4])
+		if err != nil {
+			continue
+		}
+		return pid, nil
+	}
+	return 0, nil
+}
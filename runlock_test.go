@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRunLockStatus(t *testing.T) {
+	t.Setenv("MINECRAFT_BACKUP_STATE_DIR", t.TempDir())
+	multi := &MultiServerConfig{RunLock: runLockFail}
+
+	if holder, err := runLockStatus(); err != nil || holder != nil {
+		t.Fatalf("runLockStatus() without lock file = %v, %v", holder, err)
+	}
+
+	lock, err := acquireRunLock(context.Background(), multi, "backup")
+	if err != nil {
+		t.Fatalf("acquireRunLock: %v", err)
+	}
+	holder, err := runLockStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder == nil || holder.PID != os.Getpid() || holder.Command != "backup" {
+		t.Fatalf("runLockStatus() while held = %+v", holder)
+	}
+	lock.release()
+
+	// 锁文件仍然存在，但没有进程持有锁
+	if holder, err := runLockStatus(); err != nil || holder != nil {
+		t.Fatalf("runLockStatus() after release = %v, %v", holder, err)
+	}
+}
+
+func TestRunLockStatusStaleHolder(t *testing.T) {
+	t.Setenv("MINECRAFT_BACKUP_STATE_DIR", t.TempDir())
+
+	// 持有者被 SIGKILL 后锁文件中仍留有信息，记录的 PID 可能已被其他进程复用
+	data, _ := json.Marshal(runLockHolder{PID: os.Getppid(), Command: "backup", Started: time.Now()})
+	if err := os.WriteFile(runLockPath(), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if holder, err := runLockStatus(); err != nil || holder != nil {
+		t.Fatalf("runLockStatus() with stale holder = %v, %v", holder, err)
+	}
+}
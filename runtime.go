@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 支持的容器运行时
+const (
+	runtimeDocker  = "docker"
+	runtimePodman  = "podman"
+	runtimeNerdctl = "nerdctl"
+)
+
+// errContainerNotFound 容器不存在
+var errContainerNotFound = errors.New("容器不存在")
+
+// isNotFoundError 判断是否为容器不存在错误
+func isNotFoundError(err error) bool {
+	return errors.Is(err, errContainerNotFound)
+}
+
+// ContainerRuntime 容器运行时接口
+type ContainerRuntime interface {
+	// String 返回运行时描述（用于日志）
+	String() string
+	// Ping 检查运行时是否可用
+	Ping(ctx context.Context) error
+	// ListContainers 列出容器（all 为 false 时只列出运行中的容器）
+	ListContainers(ctx context.Context, all bool) ([]ContainerSummary, error)
+	// InspectContainer 获取容器详情，容器不存在时返回 errContainerNotFound
+	InspectContainer(ctx context.Context, name string) (*ContainerInfo, error)
+	// StopContainer 停止容器（timeout 为等待容器自行退出的时间）
+	StopContainer(ctx context.Context, name string, timeout time.Duration) error
+	// StartContainer 启动容器
+	StartContainer(ctx context.Context, name string) error
+	// Exec 在容器内执行命令并返回输出，退出码非 0 时返回错误
+	Exec(ctx context.Context, name string, timeout time.Duration, cmd ...string) (string, error)
+	// Logs 获取容器自 since 以来的日志
+	Logs(ctx context.Context, name string, since time.Time) (string, error)
+}
+
+// ContainerSummary 容器列表项
+type ContainerSummary struct {
+	ID     string
+	Name   string
+	State  string
+	Status string
+	Labels map[string]string
+}
+
+// ContainerMount 容器挂载信息
+type ContainerMount struct {
+	Type        string `json:"Type"`
+	Name        string `json:"Name"`
+	Source      string `json:"Source"`
+	Destination string `json:"Destination"`
+	Driver      string `json:"Driver"`
+	RW          bool   `json:"RW"`
+}
+
+// ContainerInfo 容器详情（GET /containers/{id}/json）
+type ContainerInfo struct {
+	ID    string `json:"Id"`
+	Name  string `json:"Name"`
+	State struct {
+		Status  string `json:"Status"`
+		Running bool   `json:"Running"`
+		Paused  bool   `json:"Paused"`
+		Health  *struct {
+			Status string `json:"Status"`
+		} `json:"Health"`
+	} `json:"State"`
+	Config struct {
+		Tty    bool              `json:"Tty"`
+		Env    []string          `json:"Env"`
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+	Mounts          []ContainerMount `json:"Mounts"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress string `json:"IPAddress"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// HealthStatus 返回健康检查状态（未配置健康检查时为空）
+func (i *ContainerInfo) HealthStatus() string {
+	if i.State.Health == nil {
+		return ""
+	}
+	return i.State.Health.Status
+}
+
+// cliRuntime 通过命令行工具（podman、nerdctl）操作容器
+// 这些工具的 inspect 输出与 Docker Engine API 格式兼容
+type cliRuntime struct {
+	binary string
+}
+
+// String 返回运行时描述
+func (r *cliRuntime) String() string {
+	return fmt.Sprintf("%s (命令行)", r.binary)
+}
+
+// run 执行命令并返回 stdout
+func (r *cliRuntime) run(ctx context.Context, timeout time.Duration, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	logger.Debug("执行: %s %s", r.binary, strings.Join(args, " "))
+	cmd := exec.CommandContext(ctx, r.binary, args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if ctx.Err() != nil {
+		return string(output), ctx.Err()
+	}
+	if err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if strings.Contains(strings.ToLower(message), "no such container") {
+			return string(output), fmt.Errorf("%w: %s", errContainerNotFound, message)
+		}
+		return string(output), fmt.Errorf("%s %s 失败: %v: %s", r.binary, args[0], err, message)
+	}
+	return string(output), nil
+}
+
+// Ping 检查运行时是否可用
+func (r *cliRuntime) Ping(ctx context.Context) error {
+	if _, err := exec.LookPath(r.binary); err != nil {
+		return fmt.Errorf("未找到 %s 命令", r.binary)
+	}
+	_, err := r.run(ctx, dockerRequestTimeout, "version")
+	return err
+}
+
+// ListContainers 列出容器
+func (r *cliRuntime) ListContainers(ctx context.Context, all bool) ([]ContainerSummary, error) {
+	args := []string{"ps", "-q", "--no-trunc"}
+	if all {
+		args = append(args, "-a")
+	}
+	output, err := r.run(ctx, dockerRequestTimeout, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := strings.Fields(output)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	infos, err := r.inspect(ctx, ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]ContainerSummary, 0, len(infos))
+	for _, info := range infos {
+		summaries = append(summaries, ContainerSummary{
+			ID:     info.ID,
+			Name:   strings.TrimPrefix(info.Name, "/"),
+			State:  info.State.Status,
+			Status: info.State.Status,
+			Labels: info.Config.Labels,
+		})
+	}
+	return summaries, nil
+}
+
+// inspect 获取一个或多个容器的详情
+func (r *cliRuntime) inspect(ctx context.Context, names ...string) ([]ContainerInfo, error) {
+	output, err := r.run(ctx, dockerRequestTimeout, append([]string{"inspect"}, names...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []ContainerInfo
+	if err := json.Unmarshal([]byte(output), &infos); err != nil {
+		return nil, fmt.Errorf("解析 %s inspect 输出失败: %v", r.binary, err)
+	}
+	return infos, nil
+}
+
+// InspectContainer 获取容器详情
+func (r *cliRuntime) InspectContainer(ctx context.Context, name string) (*ContainerInfo, error) {
+	infos, err := r.inspect(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, fmt.Errorf("%w: %s", errContainerNotFound, name)
+	}
+	return &infos[0], nil
+}
+
+// StopContainer 停止容器
+func (r *cliRuntime) StopContainer(ctx context.Context, name string, timeout time.Duration) error {
+	_, err := r.run(ctx, timeout+dockerRequestTimeout, "stop", "-t", strconv.Itoa(int(timeout.Seconds())), name)
+	return err
+}
+
+// StartContainer 启动容器
+func (r *cliRuntime) StartContainer(ctx context.Context, name string) error {
+	_, err := r.run(ctx, dockerRequestTimeout, "start", name)
+	return err
+}
+
+// Exec 在容器内执行命令
+func (r *cliRuntime) Exec(ctx context.Context, name string, timeout time.Duration, cmd ...string) (string, error) {
+	return r.run(ctx, timeout, append([]string{"exec", name}, cmd...)...)
+}
+
+// Logs 获取容器日志
+func (r *cliRuntime) Logs(ctx context.Context, name string, since time.Time) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dockerRequestTimeout)
+	defer cancel()
+
+	// 容器日志可能同时写入 stdout 和 stderr
+	cmd := exec.CommandContext(ctx, r.binary, "logs", "--since", since.Format(time.RFC3339), name)
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+// podmanSocketPath 返回 Podman Docker 兼容套接字路径（支持 CONTAINER_HOST=unix://...）
+func podmanSocketPath() string {
+	if host := os.Getenv("CONTAINER_HOST"); strings.HasPrefix(host, "unix://") {
+		return strings.TrimPrefix(host, "unix://")
+	}
+	if os.Geteuid() == 0 {
+		return "/run/podman/podman.sock"
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "podman", "podman.sock")
+	}
+	return filepath.Join("/run/user", strconv.Itoa(os.Getuid()), "podman", "podman.sock")
+}
+
+// newContainerRuntime 根据名称创建容器运行时
+func newContainerRuntime(name string) (ContainerRuntime, error) {
+	switch name {
+	case "", runtimeDocker:
+		return newDockerClient(runtimeDocker, dockerSocketPath()), nil
+	case runtimePodman:
+		// 优先使用 Podman 的 Docker 兼容套接字（podman.socket），否则使用命令行
+		if socket := podmanSocketPath(); isSocket(socket) {
+			return newDockerClient(runtimePodman, socket), nil
+		}
+		return &cliRuntime{binary: runtimePodman}, nil
+	case runtimeNerdctl:
+		return &cliRuntime{binary: runtimeNerdctl}, nil
+	default:
+		return nil, fmt.Errorf("不支持的容器运行时: %s（可选 docker、podman、nerdctl）", name)
+	}
+}
+
+// isSocket 判断路径是否为 unix 套接字
+func isSocket(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeSocket != 0
+}
+
+// 已创建的容器运行时（按名称缓存）
+var (
+	runtimesMu sync.Mutex
+	runtimes   = make(map[string]ContainerRuntime)
+)
+
+// getContainerRuntime 获取指定名称的容器运行时
+func getContainerRuntime(name string) (ContainerRuntime, error) {
+	if name == "" {
+		name = runtimeDocker
+	}
+
+	runtimesMu.Lock()
+	defer runtimesMu.Unlock()
+
+	if rt, ok := runtimes[name]; ok {
+		return rt, nil
+	}
+	rt, err := newContainerRuntime(name)
+	if err != nil {
+		return nil, err
+	}
+	runtimes[name] = rt
+	return rt, nil
+}
+
+// runtimeFor 返回服务器使用的容器运行时
+func runtimeFor(config *Config) (ContainerRuntime, error) {
+	return getContainerRuntime(config.Runtime)
+}
+
+// checkRuntimes 检查服务器实际使用的容器运行时是否可用
+func checkRuntimes(ctx context.Context, multiConfig *MultiServerConfig) error {
+	used := make(map[string]bool)
+	for _, config := range multiConfig.Servers {
+		if !isContainerServer(config) {
+			continue
+		}
+		name := config.Runtime
+		if name == "" {
+			name = runtimeDocker
+		}
+		used[name] = true
+	}
+
+	names := make([]string, 0, len(used))
+	for name := range used {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		rt, err := getContainerRuntime(name)
+		if err != nil {
+			return err
+		}
+		if err := rt.Ping(ctx); err != nil {
+			logger.Log("错误: 容器运行时 %s 不可用: %v", rt, err)
+			logger.Log("请确认服务已启动，且当前用户有权限访问")
+			return fmt.Errorf("容器运行时 %s 不可用", name)
+		}
+		logger.Debug("容器运行时可用: %s", rt)
+	}
+	return nil
+}
+
+// containerWorldDirPrefix world_dir 使用容器内路径时的前缀，例如 container:/data
+const containerWorldDirPrefix = "container:"
+
+// hostPathForMount 返回容器内路径对应的主机路径
+// 路径位于某个挂载点之下时使用最长匹配的挂载点，并拼接剩余的子路径
+func hostPathForMount(info *ContainerInfo, containerPath string) (string, error) {
+	containerPath = path.Clean(containerPath)
+
+	var matched *ContainerMount
+	for i := range info.Mounts {
+		mount := &info.Mounts[i]
+		destination := path.Clean(mount.Destination)
+		if destination != containerPath && !strings.HasPrefix(containerPath, strings.TrimSuffix(destination, "/")+"/") {
+			continue
+		}
+		if matched == nil || len(destination) > len(path.Clean(matched.Destination)) {
+			matched = mount
+		}
+	}
+	if matched == nil {
+		return "", fmt.Errorf("容器没有挂载 %s", containerPath)
+	}
+
+	switch {
+	case matched.Type == "bind":
+	case matched.Type == "volume" && (matched.Driver == "" || matched.Driver == "local"):
+	default:
+		return "", fmt.Errorf("%s 挂载的是 %s（驱动 %s），无法从主机直接读取", matched.Destination, matched.Type, matched.Driver)
+	}
+	if matched.Source == "" {
+		return "", fmt.Errorf("%s 的挂载没有主机路径", matched.Destination)
+	}
+
+	relative := strings.TrimPrefix(containerPath, path.Clean(matched.Destination))
+	return filepath.Join(matched.Source, filepath.FromSlash(relative)), nil
+}
+
+// worldDirCacheFile 记录 container:/path 解析结果的文件名（位于状态目录中）
+// 容器被删除（如 docker compose down）后仍能恢复到原来的世界目录
+const worldDirCacheFile = "world-dirs.json"
+
+// resolvedWorldDir 一次 container:/path 的解析结果
+type resolvedWorldDir struct {
+	WorldDir string `json:"world_dir"`
+	Path     string `json:"path"`
+}
+
+// worldDirCacheMu 并行备份时保护解析结果文件
+var worldDirCacheMu sync.Mutex
+
+// containerWorldPath 返回 world_dir 中的容器内路径，不是 container:/path 形式时返回空字符串
+func containerWorldPath(config *Config) (string, error) {
+	containerPath, ok := strings.CutPrefix(config.WorldDir, containerWorldDirPrefix)
+	if !ok {
+		return "", nil
+	}
+	if !isContainerServer(config) {
+		return "", fmt.Errorf("world_dir 使用 %s 前缀时服务器类型必须为 docker", containerWorldDirPrefix)
+	}
+	if !path.IsAbs(containerPath) {
+		return "", fmt.Errorf("world_dir 的容器内路径必须是绝对路径: %s", containerPath)
+	}
+	return containerPath, nil
+}
+
+// resolveWorldDir 将 world_dir = "container:/path" 解析为容器挂载对应的主机路径
+// 在备份、恢复各个服务器时才解析，一个容器无法访问不影响其他服务器和不需要世界目录的命令
+// 容器已被删除时使用上次成功解析的结果
+func resolveWorldDir(ctx context.Context, serverName string, config *Config) error {
+	containerPath, err := containerWorldPath(config)
+	if err != nil || containerPath == "" {
+		return err
+	}
+
+	rt, err := runtimeFor(config)
+	if err != nil {
+		return err
+	}
+	info, err := rt.InspectContainer(ctx, config.MCContainer)
+	if isNotFoundError(err) {
+		if cached, ok := loadWorldDirCache()[serverName]; ok && cached.WorldDir == config.WorldDir {
+			logger.Log("[%s] 容器 %s 不存在，使用上次解析的世界目录 %s", serverName, config.MCContainer, cached.Path)
+			config.WorldDir = cached.Path
+			return nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("无法获取容器 %s 的挂载信息: %v", config.MCContainer, err)
+	}
+	worldDir, err := hostPathForMount(info, containerPath)
+	if err != nil {
+		return fmt.Errorf("无法解析 world_dir: %v", err)
+	}
+
+	logger.Debug("服务器 %s 的世界目录 %s 解析为 %s", serverName, config.WorldDir, worldDir)
+	saveWorldDirCache(serverName, resolvedWorldDir{WorldDir: config.WorldDir, Path: worldDir})
+	config.WorldDir = worldDir
+	return nil
+}
+
+// resolveWorldDirs 解析所有服务器的 container:/path，返回无法解析的问题列表
+// world_dir 本身的格式错误由 validateConfig 报告
+func resolveWorldDirs(ctx context.Context, multiConfig *MultiServerConfig) []string {
+	var problems []string
+	for _, serverName := range sortedServerNames(multiConfig) {
+		config := multiConfig.Servers[serverName]
+		if _, err := containerWorldPath(config); err != nil {
+			continue
+		}
+		if err := resolveWorldDir(ctx, serverName, config); err != nil {
+			problems = append(problems, fmt.Sprintf("[servers.%s] %v", serverName, err))
+		}
+	}
+	return problems
+}
+
+// loadWorldDirCache 读取上次的解析结果
+func loadWorldDirCache() map[string]resolvedWorldDir {
+	cache := make(map[string]resolvedWorldDir)
+	data, err := os.ReadFile(filepath.Join(stateDir(), worldDirCacheFile))
+	if err == nil {
+		json.Unmarshal(data, &cache)
+	}
+	return cache
+}
+
+// saveWorldDirCache 记录服务器的解析结果，结果没有变化时不写入
+func saveWorldDirCache(serverName string, resolved resolvedWorldDir) {
+	worldDirCacheMu.Lock()
+	defer worldDirCacheMu.Unlock()
+
+	cache := loadWorldDirCache()
+	if cache[serverName] == resolved {
+		return
+	}
+	cache[serverName] = resolved
+
+	dir := stateDir()
+	data, _ := json.MarshalIndent(cache, "", "  ")
+	err := os.MkdirAll(dir, 0700)
+	if err == nil {
+		tmp := filepath.Join(dir, worldDirCacheFile+".tmp")
+		if err = os.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, filepath.Join(dir, worldDirCacheFile))
+		}
+	}
+	if err != nil {
+		logger.Debug("无法保存世界目录解析结果: %v", err)
+	}
+}
+
+// containerStopTimeout 停止容器时等待服务器保存并退出的时间
+const containerStopTimeout = 60 * time.Second
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// fakeRuntime 只实现 ListContainers 和 InspectContainer 的容器运行时
+type fakeRuntime struct {
+	ContainerRuntime
+	containers map[string]*ContainerInfo
+	// ListContainers 返回的错误（模拟套接字不可用）
+	listErr error
+}
+
+func (r *fakeRuntime) String() string { return "fake" }
+
+func (r *fakeRuntime) ListContainers(ctx context.Context, all bool) ([]ContainerSummary, error) {
+	if r.listErr != nil {
+		return nil, r.listErr
+	}
+	var summaries []ContainerSummary
+	for name, info := range r.containers {
+		summaries = append(summaries, ContainerSummary{Name: name, Labels: info.Config.Labels})
+	}
+	return summaries, nil
+}
+
+func (r *fakeRuntime) InspectContainer(ctx context.Context, name string) (*ContainerInfo, error) {
+	if info, ok := r.containers[name]; ok {
+		return info, nil
+	}
+	return nil, fmt.Errorf("%w: %s", errContainerNotFound, name)
+}
+
+// useFakeRuntime 让 docker 类型的服务器使用 fakeRuntime
+func useFakeRuntime(t *testing.T, rt *fakeRuntime) {
+	t.Helper()
+	runtimesMu.Lock()
+	previous, ok := runtimes[runtimeDocker]
+	runtimes[runtimeDocker] = rt
+	runtimesMu.Unlock()
+	t.Cleanup(func() {
+		runtimesMu.Lock()
+		defer runtimesMu.Unlock()
+		if ok {
+			runtimes[runtimeDocker] = previous
+		} else {
+			delete(runtimes, runtimeDocker)
+		}
+	})
+}
+
+func TestHostPathForMount(t *testing.T) {
+	info := &ContainerInfo{Mounts: []ContainerMount{
+		{Type: "bind", Source: "/srv/mc", Destination: "/data"},
+		{Type: "bind", Source: "/srv/worlds", Destination: "/data/worlds"},
+		{Type: "volume", Source: "/var/lib/docker/volumes/mods/_data", Destination: "/mods", Driver: "local"},
+		{Type: "volume", Source: "/mnt/nfs", Destination: "/remote", Driver: "nfs"},
+		{Type: "tmpfs", Destination: "/tmp"},
+	}}
+
+	tests := []struct {
+		containerPath string
+		want          string
+		wantErr       bool
+	}{
+		{"/data", "/srv/mc", false},
+		{"/data/world", "/srv/mc/world", false},
+		{"/data/worlds/survival", "/srv/worlds/survival", false},
+		{"/data/worldsbackup", "/srv/mc/worldsbackup", false},
+		{"/mods/", "/var/lib/docker/volumes/mods/_data", false},
+		{"/remote/world", "", true},
+		{"/tmp/world", "", true},
+		{"/other", "", true},
+	}
+	for _, tt := range tests {
+		got, err := hostPathForMount(info, tt.containerPath)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("hostPathForMount(%q) = %q, %v; want %q, error %v", tt.containerPath, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestResolveWorldDirRemovedContainer(t *testing.T) {
+	t.Setenv("MINECRAFT_BACKUP_STATE_DIR", t.TempDir())
+	rt := &fakeRuntime{containers: map[string]*ContainerInfo{
+		"mc": {Mounts: []ContainerMount{{Type: "bind", Source: "/srv/mc", Destination: "/data"}}},
+	}}
+	useFakeRuntime(t, rt)
+
+	config := &Config{Type: serverTypeDocker, MCContainer: "mc", WorldDir: "container:/data/world"}
+	if err := resolveWorldDir(context.Background(), "survival", config); err != nil {
+		t.Fatal(err)
+	}
+	if config.WorldDir != "/srv/mc/world" {
+		t.Fatalf("WorldDir = %q", config.WorldDir)
+	}
+
+	// docker compose down 之后容器不存在，使用上次解析的结果
+	delete(rt.containers, "mc")
+	config = &Config{Type: serverTypeDocker, MCContainer: "mc", WorldDir: "container:/data/world"}
+	if err := resolveWorldDir(context.Background(), "survival", config); err != nil {
+		t.Fatalf("resolveWorldDir after the container was removed: %v", err)
+	}
+	if config.WorldDir != "/srv/mc/world" {
+		t.Fatalf("WorldDir = %q", config.WorldDir)
+	}
+
+	// world_dir 修改后不能再使用旧的结果
+	config = &Config{Type: serverTypeDocker, MCContainer: "mc", WorldDir: "container:/data/other"}
+	if err := resolveWorldDir(context.Background(), "survival", config); err == nil {
+		t.Fatalf("resolveWorldDir used a cached path for a different world_dir: %q", config.WorldDir)
+	}
+}
+
+func TestResolveWorldDirsPerServer(t *testing.T) {
+	t.Setenv("MINECRAFT_BACKUP_STATE_DIR", t.TempDir())
+	useFakeRuntime(t, &fakeRuntime{containers: map[string]*ContainerInfo{
+		"mc-a": {Mounts: []ContainerMount{{Type: "bind", Source: "/srv/a", Destination: "/data"}}},
+	}})
+
+	multi := &MultiServerConfig{Servers: map[string]*Config{
+		"a":     {Type: serverTypeDocker, MCContainer: "mc-a", WorldDir: "container:/data/world"},
+		"b":     {Type: serverTypeDocker, MCContainer: "mc-b", WorldDir: "container:/data/world"},
+		"local": {Type: serverTypeTmux, WorldDir: "/srv/local/world"},
+	}}
+
+	// 一个容器无法访问只影响该服务器
+	problems := resolveWorldDirs(context.Background(), multi)
+	if len(problems) != 1 {
+		t.Fatalf("problems = %q, want one problem for server b", problems)
+	}
+	if got := multi.Servers["a"].WorldDir; got != "/srv/a/world" {
+		t.Errorf("server a WorldDir = %q", got)
+	}
+	if got := multi.Servers["local"].WorldDir; got != "/srv/local/world" {
+		t.Errorf("server local WorldDir = %q", got)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// envReferencePattern 匹配 ${NAME} 形式的环境变量引用
+var envReferencePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// systemd LoadCredential= 的默认凭证名称
+// 配置文件中没有设置对应的值时，会在 $CREDENTIALS_DIRECTORY 中查找这些文件
+const (
+	credentialResticPassword     = "restic-password"
+	credentialAWSSecretAccessKey = "aws-secret-access-key"
+)
+
+// interpolateEnv 将值中的 ${NAME} 替换为环境变量，引用的变量不存在时返回错误
+func interpolateEnv(value string) (string, error) {
+	var missing []string
+	result := envReferencePattern.ReplaceAllStringFunc(value, func(match string) string {
+		name := envReferencePattern.FindStringSubmatch(match)[1]
+		envValue, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return envValue
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("环境变量 %s 未设置", strings.Join(missing, ", "))
+	}
+	return result, nil
+}
+
+// credentialPath 解析凭证文件路径
+// 设置了 $CREDENTIALS_DIRECTORY（systemd LoadCredential=）时，相对路径在该目录中查找
+func credentialPath(path string) string {
+	path = expandHome(path)
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); dir != "" && !filepath.IsAbs(path) {
+		return filepath.Join(dir, path)
+	}
+	return path
+}
+
+// defaultCredential 返回 $CREDENTIALS_DIRECTORY 中存在的默认凭证文件路径（不存在时为空）
+func defaultCredential(name string) string {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return ""
+	}
+	path := filepath.Join(dir, name)
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+	return path
+}
+
+// readSecretFile 读取凭证文件，去掉结尾的换行
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// interpolateFields 对多个配置值执行环境变量替换
+func interpolateFields(section string, fields map[string]*string) error {
+	for key, value := range fields {
+		resolved, err := interpolateEnv(*value)
+		if err != nil {
+			return fmt.Errorf("%s %s: %v", section, key, err)
+		}
+		*value = resolved
+	}
+	return nil
+}
+
+// resolveResticSecrets 解析 Restic 配置中的环境变量引用和密码文件路径
+func resolveResticSecrets(section string, restic *ResticConfig) error {
+	err := interpolateFields(section, map[string]*string{
+		"repository":       &restic.Repository,
+		"password":         &restic.Password,
+		"password_file":    &restic.PasswordFile,
+		"password_command": &restic.PasswordCommand,
+	})
+	if err != nil {
+		return err
+	}
+
+	// 密码文件由 restic 自己读取，这里只解析路径
+	if restic.PasswordFile != "" {
+		restic.PasswordFile = credentialPath(restic.PasswordFile)
+	}
+	return nil
+}
+
+// resolveAWSSecrets 解析 AWS 配置中的环境变量引用，并读取 secret_access_key_file
+func resolveAWSSecrets(section string, aws *AWSConfig) error {
+	err := interpolateFields(section, map[string]*string{
+		"access_key_id":          &aws.AccessKeyID,
+		"secret_access_key":      &aws.SecretAccessKey,
+		"secret_access_key_file": &aws.SecretAccessKeyFile,
+		"region":                 &aws.Region,
+	})
+	if err != nil {
+		return err
+	}
+
+	if aws.SecretAccessKeyFile == "" {
+		return nil
+	}
+	if aws.SecretAccessKey != "" {
+		return fmt.Errorf("%s 不能同时设置 secret_access_key 和 secret_access_key_file", section)
+	}
+	secret, err := readSecretFile(credentialPath(aws.SecretAccessKeyFile))
+	if err != nil {
+		return fmt.Errorf("%s 无法读取 secret_access_key_file: %v", section, err)
+	}
+	aws.SecretAccessKey = secret
+	return nil
+}
+
+// resolveSecrets 解析配置文件中所有凭证的来源：
+// ${ENV} 环境变量引用、凭证文件，以及 systemd 的 $CREDENTIALS_DIRECTORY
+func resolveSecrets(tomlConfig *TOMLConfig) error {
+	// 全局配置没有设置密码时，使用 systemd 传入的默认凭证
+	restic := &tomlConfig.Restic
+	if restic.Password == "" && restic.PasswordFile == "" && restic.PasswordCommand == "" {
+		restic.PasswordFile = defaultCredential(credentialResticPassword)
+	}
+	aws := &tomlConfig.AWS
+	if aws.SecretAccessKey == "" && aws.SecretAccessKeyFile == "" {
+		aws.SecretAccessKeyFile = defaultCredential(credentialAWSSecretAccessKey)
+	}
+
+	if err := resolveResticSecrets("[restic]", restic); err != nil {
+		return err
+	}
+	if err := resolveAWSSecrets("[aws]", aws); err != nil {
+		return err
+	}
+
+	for i := range tomlConfig.Mirrors {
+		mirror := &tomlConfig.Mirrors[i]
+		section := fmt.Sprintf("[[mirrors]] %s", mirror.Name)
+		if err := resolveResticSecrets(section, &mirror.ResticConfig); err != nil {
+			return err
+		}
+		if err := resolveAWSSecrets(section+" aws", &mirror.AWS); err != nil {
+			return err
+		}
+	}
+
+	for serverName, serverConfig := range tomlConfig.Servers {
+		if err := resolveResticSecrets(fmt.Sprintf("[servers.%s.restic]", serverName), &serverConfig.Restic); err != nil {
+			return err
+		}
+		if err := resolveAWSSecrets(fmt.Sprintf("[servers.%s.aws]", serverName), &serverConfig.AWS); err != nil {
+			return err
+		}
+		rconPassword, err := interpolateEnv(serverConfig.RconPassword)
+		if err != nil {
+			return fmt.Errorf("[servers.%s] rcon_password: %v", serverName, err)
+		}
+		serverConfig.RconPassword = rconPassword
+		tomlConfig.Servers[serverName] = serverConfig
+	}
+	return nil
+}
+
+// hasPlaintextSecrets 判断配置文件中是否直接写有密码或密钥（未使用 ${ENV} 引用）
+func hasPlaintextSecrets(tomlConfig *TOMLConfig) bool {
+	isPlain := func(value string) bool {
+		return value != "" && !envReferencePattern.MatchString(value)
+	}
+
+	if isPlain(tomlConfig.Restic.Password) || isPlain(tomlConfig.AWS.SecretAccessKey) {
+		return true
+	}
+	for _, mirror := range tomlConfig.Mirrors {
+		if isPlain(mirror.Password) || isPlain(mirror.AWS.SecretAccessKey) {
+			return true
+		}
+	}
+	for _, serverConfig := range tomlConfig.Servers {
+		if isPlain(serverConfig.Restic.Password) || isPlain(serverConfig.AWS.SecretAccessKey) || isPlain(serverConfig.RconPassword) {
+			return true
+		}
+	}
+	return false
+}
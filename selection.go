@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// isGlobPattern 判断是否包含通配符
+func isGlobPattern(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// matchServerName 判断服务器名称是否匹配任意一个模式
+func matchServerName(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// selectServers 按名称选择需要处理的服务器
+// include 为空时选择所有启用的服务器；精确指定未启用的服务器需要 includeDisabled
+func selectServers(multiConfig *MultiServerConfig, include, exclude []string, includeDisabled bool) error {
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return usageError(fmt.Sprintf("无效的服务器匹配模式: %s", pattern))
+		}
+	}
+
+	selected := make(map[string]*Config)
+
+	if len(include) == 0 {
+		for name, config := range multiConfig.Servers {
+			if config.Enabled || includeDisabled {
+				selected[name] = config
+			} else {
+				logger.Log("跳过未启用的服务器: %s", name)
+			}
+		}
+	} else {
+		for _, pattern := range include {
+			if !isGlobPattern(pattern) {
+				config, ok := multiConfig.Servers[pattern]
+				if !ok {
+					return fmt.Errorf("服务器 %s 不存在", pattern)
+				}
+				if !config.Enabled && !includeDisabled {
+					return fmt.Errorf("服务器 %s 未启用（使用 --include-disabled 强制处理）", pattern)
+				}
+				selected[pattern] = config
+				continue
+			}
+
+			matchedAny := false
+			for name, config := range multiConfig.Servers {
+				if !matchServerName(name, []string{pattern}) {
+					continue
+				}
+				matchedAny = true
+				if config.Enabled || includeDisabled {
+					selected[name] = config
+				} else {
+					logger.Log("跳过未启用的服务器: %s", name)
+				}
+			}
+			if !matchedAny {
+				return fmt.Errorf("没有与 %s 匹配的服务器", pattern)
+			}
+		}
+	}
+
+	for name := range selected {
+		if matchServerName(name, exclude) {
+			logger.Debug("排除服务器: %s", name)
+			delete(selected, name)
+		}
+	}
+
+	for name, config := range selected {
+		if !config.Enabled {
+			logger.Log("强制处理未启用的服务器: %s", name)
+		}
+	}
+
+	if len(selected) == 0 {
+		if len(include) == 0 && len(exclude) == 0 {
+			return fmt.Errorf("没有启用的服务器，请在配置文件中将需要备份的服务器设置为 enabled = true")
+		}
+		return fmt.Errorf("没有符合条件的服务器")
+	}
+
+	if multiConfig.AllServers == nil {
+		multiConfig.AllServers = multiConfig.Servers
+	}
+	multiConfig.Servers = selected
+	return nil
+}
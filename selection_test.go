@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testServers 返回用于选择服务器的配置：survival、minigame-a、minigame-b 启用，minigame-test 未启用
+func testServers() *MultiServerConfig {
+	return &MultiServerConfig{Servers: map[string]*Config{
+		"survival":      {Enabled: true},
+		"minigame-a":    {Enabled: true},
+		"minigame-b":    {Enabled: true},
+		"minigame-test": {Enabled: false},
+	}}
+}
+
+func TestSelectServers(t *testing.T) {
+	tests := []struct {
+		name            string
+		include         []string
+		exclude         []string
+		includeDisabled bool
+		want            []string
+	}{
+		{"all enabled", nil, nil, false, []string{"minigame-a", "minigame-b", "survival"}},
+		{"include disabled", nil, nil, true, []string{"minigame-a", "minigame-b", "minigame-test", "survival"}},
+		{"exact name", []string{"survival"}, nil, false, []string{"survival"}},
+		{"glob skips disabled", []string{"minigame-*"}, nil, false, []string{"minigame-a", "minigame-b"}},
+		{"glob with disabled", []string{"minigame-*"}, nil, true, []string{"minigame-a", "minigame-b", "minigame-test"}},
+		{"exclude", []string{"minigame-*"}, []string{"minigame-b"}, false, []string{"minigame-a"}},
+		{"exclude glob", nil, []string{"minigame-?"}, false, []string{"survival"}},
+		{"character class", []string{"minigame-[ab]"}, nil, false, []string{"minigame-a", "minigame-b"}},
+		{"disabled by exact name", []string{"minigame-test"}, nil, true, []string{"minigame-test"}},
+		{"duplicate patterns", []string{"survival", "surv*"}, nil, false, []string{"survival"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			multi := testServers()
+			if err := selectServers(multi, tt.include, tt.exclude, tt.includeDisabled); err != nil {
+				t.Fatalf("selectServers() error = %v", err)
+			}
+			if got := sortedServerNames(multi); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selected = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectServersErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		usage   bool
+	}{
+		{"unknown server", []string{"creative"}, nil, false},
+		{"disabled by exact name", []string{"minigame-test"}, nil, false},
+		{"glob matches nothing", []string{"creative-*"}, nil, false},
+		{"everything excluded", []string{"survival"}, []string{"*"}, false},
+		{"invalid pattern", []string{"minigame-["}, nil, true},
+		{"invalid exclude pattern", nil, []string{"["}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			multi := testServers()
+			err := selectServers(multi, tt.include, tt.exclude, false)
+			if err == nil {
+				t.Fatalf("selectServers() selected %q, want an error", sortedServerNames(multi))
+			}
+			var usage usageError
+			if errors.As(err, &usage) != tt.usage {
+				t.Errorf("selectServers() error = %v (%T), usage error %v", err, err, tt.usage)
+			}
+			if len(multi.Servers) != 4 {
+				t.Errorf("selectServers() modified the configuration on error")
+			}
+		})
+	}
+}
+
+func TestSelectServersNoneEnabled(t *testing.T) {
+	multi := &MultiServerConfig{Servers: map[string]*Config{"survival": {Enabled: false}}}
+	if err := selectServers(multi, nil, nil, false); err == nil {
+		t.Fatal("selectServers() succeeded without enabled servers")
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 服务器类型
+const (
+	serverTypeDocker  = "docker"
+	serverTypeRcon    = "rcon-only"
+	serverTypeSystemd = "systemd"
+	serverTypeTmux    = "tmux"
+	serverTypeScreen  = "screen"
+)
+
+// errUnsupported 当前服务器类型不支持该操作
+var errUnsupported = errors.New("当前服务器类型不支持该操作")
+
+// outputWatcher 返回自开始监视以来服务器新产生的输出
+type outputWatcher func(ctx context.Context) (string, error)
+
+// ServerController 控制单个 Minecraft 服务器（检查存活、发送命令、启停）
+type ServerController interface {
+	// String 返回服务器描述（用于日志）
+	String() string
+	// IsRunning 判断服务器是否正在运行
+	IsRunning(ctx context.Context) (bool, error)
+	// SendCommand 发送控制台命令（未配置 RCON 时使用），返回响应文本（可能为空）
+	SendCommand(ctx context.Context, command string, timeout time.Duration) (string, error)
+	// WatchOutput 开始监视服务器输出，用于确认保存完成（不支持时返回 errUnsupported）
+	WatchOutput() (outputWatcher, error)
+	// Stop 停止服务器
+	Stop(ctx context.Context) error
+	// Start 启动服务器
+	Start(ctx context.Context) error
+}
+
+// controllerFor 返回服务器对应的控制器
+func controllerFor(config *Config) (ServerController, error) {
+	switch config.Type {
+	case "", serverTypeDocker:
+		rt, err := runtimeFor(config)
+		if err != nil {
+			return nil, err
+		}
+		return &dockerController{runtime: rt, container: config.MCContainer}, nil
+	case serverTypeRcon:
+		return &rconController{config: config}, nil
+	case serverTypeSystemd:
+		return &systemdController{config: config, unit: config.SystemdUnit}, nil
+	case serverTypeTmux:
+		return &tmuxController{config: config, session: config.Session}, nil
+	case serverTypeScreen:
+		return &screenController{config: config, session: config.Session}, nil
+	default:
+		return nil, fmt.Errorf("不支持的服务器类型: %s（可选 docker、rcon-only、systemd、tmux、screen）", config.Type)
+	}
+}
+
+// isContainerServer 判断服务器是否运行在容器中
+func isContainerServer(config *Config) bool {
+	return config.Type == "" || config.Type == serverTypeDocker
+}
+
+// checkServerRunning 检查服务器是否运行
+func checkServerRunning(ctx context.Context, config *Config) error {
+	if isContainerServer(config) {
+		return checkContainerRunning(ctx, config)
+	}
+
+	controller, err := controllerFor(config)
+	if err != nil {
+		return err
+	}
+	running, err := controller.IsRunning(ctx)
+	if err != nil {
+		return err
+	}
+	if !running {
+		logger.Log("错误: %s 未运行", controller)
+		return fmt.Errorf("%s not running", controller)
+	}
+	return nil
+}
+
+// runCommand 执行外部命令并返回 stdout，超过 timeout 时终止
+func runCommand(ctx context.Context, timeout time.Duration, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	logger.Debug("执行: %s %s", name, strings.Join(args, " "))
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if ctx.Err() != nil {
+		return string(output), ctx.Err()
+	}
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return string(output), fmt.Errorf("%v: %s", err, message)
+		}
+		return string(output), err
+	}
+	return string(output), nil
+}
+
+// dockerController 运行在容器中的服务器
+type dockerController struct {
+	runtime   ContainerRuntime
+	container string
+}
+
+func (c *dockerController) String() string {
+	return fmt.Sprintf("容器 %s", c.container)
+}
+
+func (c *dockerController) IsRunning(ctx context.Context) (bool, error) {
+	info, err := c.runtime.InspectContainer(ctx, c.container)
+	if err != nil {
+		if isNotFoundError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return info.State.Running, nil
+}
+
+func (c *dockerController) SendCommand(ctx context.Context, command string, timeout time.Duration) (string, error) {
+	logger.Debug("执行: %s exec %s rcon-cli %s", c.runtime, c.container, command)
+	return c.runtime.Exec(ctx, c.container, timeout, append([]string{"rcon-cli"}, strings.Fields(command)...)...)
+}
+
+func (c *dockerController) WatchOutput() (outputWatcher, error) {
+	since := time.Now()
+	return func(ctx context.Context) (string, error) {
+		return c.runtime.Logs(ctx, c.container, since)
+	}, nil
+}
+
+func (c *dockerController) Stop(ctx context.Context) error {
+	return c.runtime.StopContainer(ctx, c.container, containerStopTimeout)
+}
+
+func (c *dockerController) Start(ctx context.Context) error {
+	return c.runtime.StartContainer(ctx, c.container)
+}
+
+// rconController 只能通过 RCON 访问的服务器
+type rconController struct {
+	config *Config
+}
+
+func (c *rconController) String() string {
+	return fmt.Sprintf("RCON %s", rconAddress(c.config))
+}
+
+func (c *rconController) IsRunning(ctx context.Context) (bool, error) {
+	client, err := dialRcon(ctx, rconAddress(c.config), c.config.RconPassword, c.config.RconTimeout)
+	if err != nil {
+		if errors.Is(err, errRconAuthFailed) {
+			return false, err
+		}
+		return false, nil
+	}
+	client.Close()
+	return true, nil
+}
+
+func (c *rconController) SendCommand(ctx context.Context, command string, timeout time.Duration) (string, error) {
+	return "", fmt.Errorf("rcon-only 服务器需要配置 rcon_password")
+}
+
+func (c *rconController) WatchOutput() (outputWatcher, error) {
+	return watchLogFile(c.config)
+}
+
+func (c *rconController) Stop(ctx context.Context) error {
+	return errUnsupported
+}
+
+func (c *rconController) Start(ctx context.Context) error {
+	return errUnsupported
+}
+
+// systemdController 由 systemd 管理的服务器
+type systemdController struct {
+	config *Config
+	unit   string
+}
+
+func (c *systemdController) String() string {
+	return fmt.Sprintf("systemd 服务 %s", c.unit)
+}
+
+func (c *systemdController) IsRunning(ctx context.Context) (bool, error) {
+	output, _ := runCommand(ctx, dockerRequestTimeout, "systemctl", "is-active", c.unit)
+	return strings.TrimSpace(output) == "active", nil
+}
+
+func (c *systemdController) SendCommand(ctx context.Context, command string, timeout time.Duration) (string, error) {
+	return "", fmt.Errorf("systemd 服务器需要配置 rcon_password")
+}
+
+func (c *systemdController) WatchOutput() (outputWatcher, error) {
+	if watcher, err := watchLogFile(c.config); err == nil {
+		return watcher, nil
+	}
+
+	// 没有日志文件时读取 journal
+	since := time.Now()
+	return func(ctx context.Context) (string, error) {
+		return runCommand(ctx, dockerRequestTimeout, "journalctl", "-u", c.unit,
+			"--since", "@"+strconv.FormatInt(since.Unix(), 10), "-o", "cat", "--no-pager")
+	}, nil
+}
+
+func (c *systemdController) Stop(ctx context.Context) error {
+	_, err := runCommand(ctx, containerStopTimeout+dockerRequestTimeout, "systemctl", "stop", c.unit)
+	return err
+}
+
+func (c *systemdController) Start(ctx context.Context) error {
+	_, err := runCommand(ctx, dockerRequestTimeout, "systemctl", "start", c.unit)
+	return err
+}
+
+// tmuxController 运行在 tmux 会话中的服务器，通过模拟键盘输入发送命令
+type tmuxController struct {
+	config  *Config
+	session string
+}
+
+func (c *tmuxController) String() string {
+	return fmt.Sprintf("tmux 会话 %s", c.session)
+}
+
+func (c *tmuxController) IsRunning(ctx context.Context) (bool, error) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		return false, fmt.Errorf("未找到 tmux 命令")
+	}
+	_, err := runCommand(ctx, dockerRequestTimeout, "tmux", "has-session", "-t", c.session)
+	return err == nil, nil
+}
+
+func (c *tmuxController) SendCommand(ctx context.Context, command string, timeout time.Duration) (string, error) {
+	// -l 按字面发送文本，避免命令中的内容被当成按键名称
+	if _, err := runCommand(ctx, timeout, "tmux", "send-keys", "-t", c.session, "-l", command); err != nil {
+		return "", err
+	}
+	_, err := runCommand(ctx, timeout, "tmux", "send-keys", "-t", c.session, "Enter")
+	return "", err
+}
+
+func (c *tmuxController) WatchOutput() (outputWatcher, error) {
+	return watchLogFile(c.config)
+}
+
+func (c *tmuxController) Stop(ctx context.Context) error {
+	return errUnsupported
+}
+
+func (c *tmuxController) Start(ctx context.Context) error {
+	return errUnsupported
+}
+
+// screenController 运行在 GNU screen 会话中的服务器，通过模拟键盘输入发送命令
+type screenController struct {
+	config  *Config
+	session string
+}
+
+func (c *screenController) String() string {
+	return fmt.Sprintf("screen 会话 %s", c.session)
+}
+
+func (c *screenController) IsRunning(ctx context.Context) (bool, error) {
+	if _, err := exec.LookPath("screen"); err != nil {
+		return false, fmt.Errorf("未找到 screen 命令")
+	}
+
+	// screen -ls 在有会话时也可能返回非 0，只根据输出判断
+	output, _ := runCommand(ctx, dockerRequestTimeout, "screen", "-ls", c.session)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// 会话名称格式为 <pid>.<name>
+		if parts := strings.SplitN(fields[0], ".", 2); len(parts) == 2 && parts[1] == c.session {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *screenController) SendCommand(ctx context.Context, command string, timeout time.Duration) (string, error) {
+	_, err := runCommand(ctx, timeout, "screen", "-S", c.session, "-p", "0", "-X", "stuff", command+"\r")
+	return "", err
+}
+
+func (c *screenController) WatchOutput() (outputWatcher, error) {
+	return watchLogFile(c.config)
+}
+
+func (c *screenController) Stop(ctx context.Context) error {
+	return errUnsupported
+}
+
+func (c *screenController) Start(ctx context.Context) error {
+	return errUnsupported
+}
+
+// serverLogFile 返回服务器日志文件路径
+// 未配置 log_file 时依次尝试 <world_dir>/logs/latest.log 和 <world_dir>/../logs/latest.log
+func serverLogFile(config *Config) string {
+	if config.LogFile != "" {
+		return config.LogFile
+	}
+
+	candidates := []string{
+		filepath.Join(config.WorldDir, "logs", "latest.log"),
+		filepath.Join(filepath.Dir(filepath.Clean(config.WorldDir)), "logs", "latest.log"),
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// watchLogFile 从日志文件当前末尾开始监视新写入的内容
+func watchLogFile(config *Config) (outputWatcher, error) {
+	path := serverLogFile(config)
+	if path == "" {
+		return nil, errUnsupported
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size()
+
+	return func(context.Context) (string, error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+
+		// 日志文件被轮转后从头读取
+		if info, err := file.Stat(); err == nil && info.Size() < offset {
+			offset = 0
+		}
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return "", err
+		}
+		data, err := io.ReadAll(file)
+		return string(data), err
+	}, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// exitInterrupted 被信号中断时的退出码（与 shell 中 128+SIGINT 一致）
+	exitInterrupted = 130
+
+	// resticStopTimeout 取消后等待 restic 自行退出（删除自己的锁）的时间，超时后强制结束
+	resticStopTimeout = 30 * time.Second
+)
+
+// shutdownContext 返回收到 SIGINT/SIGTERM 时取消的 context
+// 第一次信号取消正在执行的操作，已暂停写入的服务器仍会恢复写入；第二次信号立即退出
+func shutdownContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			logger.Log("收到 %s 信号，正在停止并恢复服务器写入（再次发送信号立即退出）...", sig)
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case sig := <-signals:
+			logger.Log("再次收到 %s 信号，立即退出，请手动检查服务器是否已恢复写入", sig)
+			os.Exit(exitInterrupted)
+		case <-done:
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+}
+
+// sleepContext 等待指定时间，context 取消时提前返回错误
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// saveGuard 记录已经暂停写入（save-off）的服务器，保证每个服务器最终都会恢复写入
+// 同时写入 save-off 记录，进程被强制结束时由下次启动恢复
+type saveGuard struct {
+	mu      sync.Mutex
+	journal *saveJournal
+	pending map[string]*Config
+	// 已恢复写入和恢复失败的服务器
+	restored []string
+	failed   []string
+}
+
+// newSaveGuard 创建 saveGuard，journal 为空时不写入 save-off 记录
+func newSaveGuard(journal *saveJournal) *saveGuard {
+	return &saveGuard{journal: journal, pending: make(map[string]*Config)}
+}
+
+// disable 暂停服务器的世界写入
+// 发送命令前就登记服务器：命令超时或被中断时服务器可能已经执行了 save-off
+func (g *saveGuard) disable(ctx context.Context, serverName string, config *Config) error {
+	g.mu.Lock()
+	g.pending[serverName] = config
+	g.mu.Unlock()
+
+	if g.journal != nil {
+		if err := g.journal.record(serverName); err != nil {
+			logger.Log("警告: 无法写入 save-off 记录，进程异常退出时不会自动恢复写入: %v", err)
+		}
+	}
+
+	logger.Log("[%s] 暂停 Minecraft 世界写入...", serverName)
+	return runServerCommand(ctx, serverName, config, "save-off")
+}
+
+// restore 恢复服务器的世界写入，服务器未暂停写入或已经恢复时不做任何操作
+// 使用不会被取消的 context，收到中断信号后仍然会发送 save-on
+func (g *saveGuard) restore(ctx context.Context, serverName string) error {
+	g.mu.Lock()
+	config, ok := g.pending[serverName]
+	delete(g.pending, serverName)
+	g.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	logger.Log("[%s] 恢复 Minecraft 世界写入...", serverName)
+	err := runServerCommand(context.WithoutCancel(ctx), serverName, config, "save-on")
+
+	g.mu.Lock()
+	if err != nil {
+		g.failed = append(g.failed, serverName)
+	} else {
+		g.restored = append(g.restored, serverName)
+	}
+	g.mu.Unlock()
+
+	if err != nil {
+		// 保留 save-off 记录，下次运行时重试
+		logger.Log("警告: 服务器 %s 无法执行 save-on 命令，请手动检查: %v", serverName, err)
+		return err
+	}
+	if g.journal != nil {
+		if err := g.journal.clear(serverName); err != nil {
+			logger.Log("警告: 无法更新 save-off 记录: %v", err)
+		}
+	}
+	return nil
+}
+
+// restoreAll 恢复所有仍处于暂停写入状态的服务器
+func (g *saveGuard) restoreAll(ctx context.Context) {
+	g.mu.Lock()
+	names := make([]string, 0, len(g.pending))
+	for name := range g.pending {
+		names = append(names, name)
+	}
+	g.mu.Unlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		g.restore(ctx, name)
+	}
+}
+
+// report 显示恢复写入的结果
+func (g *saveGuard) report() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	restored := append([]string(nil), g.restored...)
+	failed := append([]string(nil), g.failed...)
+	sort.Strings(restored)
+	sort.Strings(failed)
+
+	if len(restored) == 0 && len(failed) == 0 {
+		logger.Log("没有服务器处于暂停写入状态")
+		return
+	}
+	if len(restored) > 0 {
+		logger.Log("已恢复写入的服务器: %s", strings.Join(restored, ", "))
+	}
+	if len(failed) > 0 {
+		logger.Log("警告: 以下服务器未能恢复写入，请手动执行 save-on: %s", strings.Join(failed, ", "))
+	}
+}